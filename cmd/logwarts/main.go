@@ -2,103 +2,396 @@ package main
 
 import (
 	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/frederikmartin/logwarts/internal/cache"
+	"github.com/frederikmartin/logwarts/internal/config"
 	"github.com/frederikmartin/logwarts/internal/db"
+	"github.com/frederikmartin/logwarts/internal/geoip"
+	"github.com/frederikmartin/logwarts/internal/glob"
+	"github.com/frederikmartin/logwarts/internal/logger"
 	"github.com/frederikmartin/logwarts/internal/output"
 	"github.com/frederikmartin/logwarts/internal/s3"
 	"github.com/frederikmartin/logwarts/internal/session"
+	"github.com/frederikmartin/logwarts/internal/timeutil"
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
 )
 
+// ImportOptions configures runImport, the import command binds its flags to them
+type ImportOptions struct {
+	Source  string
+	LogType string
+	Format  string
+	// Delimiter, Quote, Escape and Null override the CSV options log files are read with
+	Delimiter string
+	Quote     string
+	Escape    string
+	Null      string
+	// Stdin provides the names of local files or, with StdinContent, the log content itself
+	Stdin        io.Reader
+	Glob         string
+	StdinContent bool
+	Workers      int
+	Force        bool
+
+	Bucket          string
+	Prefix          string
+	PrefixTemplate  string
+	Manifest        string
+	DownloadDir     string
+	Since           string
+	Until           string
+	SinceLastImport bool
+	DryRun          bool
+	Full            bool
+	Cleanup         bool
+	Keep            bool
+	Verify          bool
+	Decompress      bool
+	DownloadWorkers int
+	RateLimit       float64
+	AWS             s3.ClientOptions
+
+	Progress     string
+	GeoIP        []string
+	IgnoreErrors bool
+	FailOnEmpty  bool
+}
+
+var importOptions = ImportOptions{Stdin: os.Stdin}
+
 var (
-	bucket             string
-	prefix             string
-	downloadDir        string
-	source             string
-	statsRequestFilter string
+	statsRequestFilter []string
+	statsExclude       []string
+	statsInterval      string
+	statsGroupBy       string
+	statsSparkline     bool
+	statsFilterColumn  string
+	statsRulePriority  string
+	statsAction        string
+	targetStatusCode   string
+	sslCipherFilter    string
+	agentsLimit        int
+	normalizeAgents    bool
+	sampleSize         int
+	logType            string
+	pruneOrphans       bool
+	quiet              bool
+	verbose            bool
+	outputFormat       string
+	outputPath         string
+	appendOutput       bool
+	gzipOutput         bool
+	showTotals         bool
+	noHeader           bool
+	noOptimize         bool
+	nullString         string
+	nullStringSet      bool
+	selectedColumns    []string
+	histRequestFilter  string
+	countRequestFilter string
+	histBuckets        int
+	histLogScale       bool
+	inMemory           bool
+	threads            int
+	memoryLimit        string
+	configPath         string
+	explainAnalyze     bool
+	watchInterval      time.Duration
+	noCache            bool
+	showSQL            bool
+	csvHeaderOnly      bool
+	withTypes          bool
+	pruneBefore        string
+	statusClass        string
+	errorsLimit        int
+	clientsLimit       int
+	benchLines         int
+	clientsFrom        string
+	clientsTo          string
+	pagerMode          string
+	sortBy             string
+	diffMetrics        []string
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "logwarts",
-	Short: "Logwarts is a CLI tool designed for efficient and magical processing of AWS Application Load Balancer (ALB) log files. Inspired by the wizarding world, Logwarts aims to bring a bit of magic to your log analysis tasks",
+	Use:           "logwarts",
+	SilenceErrors: true,
+	Short:         "Logwarts is a CLI tool designed for efficient and magical processing of AWS Application Load Balancer (ALB) log files. Inspired by the wizarding world, Logwarts aims to bring a bit of magic to your log analysis tasks",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		logger.SetQuiet(quiet)
+		logger.SetVerbose(verbose)
+		nullStringSet = cmd.Flags().Changed("null-string")
+		applied, err := applyConfig(cmd)
+		if err != nil {
+			return err
+		}
+		if err := configureThreads(cmd, applied["threads"]); err != nil {
+			return err
+		}
+		if memoryLimit != "" {
+			if err := db.SetMemoryLimit(memoryLimit); err != nil {
+				return err
+			}
+		}
+		if inMemory {
+			session.UseInMemory()
+			return nil
+		}
+		if err := session.Init(); err != nil {
+			return fmt.Errorf("Failed to initialize session management: %v", err)
+		}
+		return nil
+	},
 }
 
 func main() {
-	if err := session.Init(); err != nil {
-		fmt.Println("Failed to initialize session management:", err)
-		os.Exit(1)
-	}
 	defer session.Close()
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		exitWithError(err)
+	}
+}
+
+// exitWithError prints err followed by a hint for errors the user can fix themselves and exits non-zero
+func exitWithError(err error) {
+	if errors.Is(err, db.ErrNoActiveSession) {
+		logger.Debugf("%v", err)
+		fmt.Fprintln(os.Stderr, "No active session. Run: logwarts session create <name>")
 		os.Exit(1)
 	}
+	fmt.Fprintln(os.Stderr, err)
+	if hint := errorHint(err); hint != "" {
+		fmt.Fprintln(os.Stderr, hint)
+	}
+	os.Exit(1)
+}
+
+func errorHint(err error) string {
+	switch {
+	case errors.Is(err, db.ErrTableMissing):
+		return "The session's log table is missing, create a new session with 'logwarts session create <name>'"
+	case errors.Is(err, db.ErrLogTypeMismatch):
+		return "Import the logs into a session created with the matching --log-type"
+	}
+	return ""
 }
 
 func init() {
-	importCmd.Flags().StringVarP(&source, "source", "s", "s3", "Source of the logs: 's3' or 'local' (local files are read from stdin)")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file with default flag values (defaults to ~/.config/logwarts/config.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&inMemory, "in-memory", false, "Use an ephemeral in-memory database instead of the active session (log files are read from stdin)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress bars and informational output")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Log debug output like executed SQL, file paths, S3 keys and timings to stderr (credentials are redacted)")
+	rootCmd.PersistentFlags().IntVar(&threads, "threads", 0, "Number of DuckDB threads (overrides LOGWARTS_THREADS, defaults to the number of CPUs)")
+	rootCmd.PersistentFlags().StringVar(&memoryLimit, "memory-limit", "", "Maximum memory DuckDB may use before spilling to disk, e.g. 4GB (defaults to DuckDB's own limit)")
+
+	importCmd.Flags().StringVarP(&importOptions.Source, "source", "s", "s3", "Source of the logs: 's3' or 'local' (local files are read from stdin)")
+	importCmd.Flags().StringVar(&importOptions.LogType, "log-type", "alb", "Load balancer log format: 'alb', 'nlb', or 'clb'")
+	importCmd.Flags().StringVar(&importOptions.Format, "format", "log", "File format of local files: 'log' for raw access logs or 'jsonl' for newline-delimited JSON with one object per entry")
+	importCmd.Flags().StringVar(&importOptions.Delimiter, "delimiter", db.DefaultCopyOptions.Delimiter, "Field delimiter of log files, a single character or \\t for tab")
+	importCmd.Flags().StringVar(&importOptions.Quote, "quote", db.DefaultCopyOptions.Quote, "Quote character of log files")
+	importCmd.Flags().StringVar(&importOptions.Escape, "escape", db.DefaultCopyOptions.Escape, "Character escaping a quote inside quoted fields of log files")
+	importCmd.Flags().StringVar(&importOptions.Null, "null", db.DefaultCopyOptions.Null, "String that stands for a missing value in log files")
+	benchCmd.Flags().IntVar(&benchLines, "lines", 1000000, "Number of synthetic log lines to generate")
+	validateCmd.Flags().StringVar(&logType, "log-type", "alb", "Load balancer log format to validate against: 'alb', 'nlb', or 'clb'")
+	fieldsCmd.Flags().StringVar(&logType, "log-type", "alb", "Load balancer log format to list the fields of: 'alb', 'nlb', or 'clb'")
+	sessionCmd.Flags().StringVar(&logType, "log-type", "alb", "Load balancer log format of the session's table: 'alb', 'nlb', or 'clb' (create only)")
+	sessionCmd.Flags().BoolVar(&pruneOrphans, "prune", false, "Remove the orphaned sessions and tables found instead of only reporting them (gc only)")
+
+	importCmd.Flags().StringVarP(&importOptions.Bucket, "bucket", "b", "", "S3 bucket name")
+	importCmd.Flags().StringVarP(&importOptions.Prefix, "prefix", "p", "", "S3 prefix (folder path) for ALB logs")
+	importCmd.Flags().StringVar(&importOptions.PrefixTemplate, "prefix-template", "", "S3 prefix with {yyyy}, {MM} and {dd} tokens, listed once per day from --since to --until (UTC) instead of --prefix")
+	importCmd.Flags().StringVarP(&importOptions.DownloadDir, "download-dir", "d", "./logs", "Local directory to store downloaded logs")
+	importCmd.Flags().StringVar(&importOptions.Since, "since", "", "Only import S3 objects last modified at or after this time (e.g. 2024-01-02, 2024-01-02 15:04 or RFC3339)")
+	importCmd.Flags().BoolVar(&importOptions.SinceLastImport, "since-last-import", false, "Only import S3 objects last modified at or after the newest object imported into the session before")
+	importCmd.Flags().StringVar(&importOptions.Until, "until", "", "Only import S3 objects last modified before this time (e.g. 2024-01-02, 2024-01-02 15:04 or RFC3339)")
+	importCmd.Flags().BoolVar(&importOptions.DryRun, "dry-run", false, "List the S3 objects that would be downloaded without downloading or importing them")
+	importCmd.Flags().IntVar(&importOptions.Workers, "workers", runtime.NumCPU(), "Number of local files to import concurrently")
+	importCmd.Flags().StringVar(&importOptions.Glob, "glob", "", "Import the local files matching this glob pattern instead of reading file names from stdin, '**' matches any number of directories")
+	importCmd.Flags().BoolVar(&importOptions.StdinContent, "stdin-content", false, "Read the log content itself from stdin instead of file names, gzip is detected automatically")
+	importCmd.Flags().BoolVar(&importOptions.Force, "force", false, "Import local files again even if they were already imported into the session with the same size")
+	importCmd.Flags().BoolVar(&importOptions.Cleanup, "cleanup", false, "Delete downloaded S3 objects from the download directory once they were imported successfully")
+	importCmd.Flags().BoolVar(&importOptions.Keep, "keep", false, "Keep downloaded S3 objects in the download directory after importing them (default)")
+	importCmd.Flags().BoolVar(&importOptions.Full, "full", false, "Download and import all S3 objects again, including those already imported into the session")
+	importCmd.Flags().IntVar(&importOptions.DownloadWorkers, "download-workers", 4, "Number of S3 objects to download concurrently")
+	importCmd.Flags().Float64Var(&importOptions.RateLimit, "rate-limit", 0, "Maximum S3 GetObject requests per second across all download workers, including retries (0 for no limit)")
+	importCmd.Flags().StringVar(&importOptions.Manifest, "manifest", "", "s3:// URL of an S3 Inventory manifest.json to take the objects from instead of listing the bucket")
+	importCmd.Flags().BoolVar(&importOptions.Decompress, "decompress", false, "Store gzipped S3 objects decompressed in the download directory")
+	importCmd.Flags().BoolVar(&importOptions.Verify, "verify", false, "Verify the size and MD5 checksum of downloaded S3 objects and re-fetch them on mismatch")
+	importCmd.Flags().StringVar(&importOptions.Progress, "progress", "bar", "Progress output: 'bar' for a progress bar or 'json' for newline-delimited JSON events on stderr")
+	importCmd.Flags().StringSliceVar(&importOptions.GeoIP, "geoip", nil, "MaxMind GeoLite2 Country/City/ASN database(s) used to fill the country, asn and as_org columns")
+	importCmd.Flags().StringVar(&importOptions.AWS.Region, "region", "", "AWS region of the bucket (defaults to the region of the AWS config)")
+	importCmd.Flags().StringVar(&importOptions.AWS.AccessKeyID, "aws-access-key-id", "", "AWS access key id, overrides the default credential chain")
+	importCmd.Flags().StringVar(&importOptions.AWS.SecretAccessKey, "aws-secret-access-key", "", "AWS secret access key, required with --aws-access-key-id")
+	importCmd.Flags().StringVar(&importOptions.AWS.SessionToken, "aws-session-token", "", "AWS session token for temporary credentials")
+	importCmd.Flags().StringVar(&importOptions.AWS.CredentialsFile, "credentials-file", "", "Shared AWS credentials file to use instead of ~/.aws/credentials")
+	importCmd.Flags().StringVar(&importOptions.AWS.AssumeRoleARN, "assume-role-arn", "", "ARN of an IAM role to assume for listing and downloading logs, e.g. in another account")
+	importCmd.Flags().StringVar(&importOptions.AWS.ExternalID, "external-id", "", "External id to pass when assuming --assume-role-arn")
+	importCmd.Flags().BoolVar(&importOptions.IgnoreErrors, "ignore-errors", false, "Exit successfully even if some files failed to import")
+	importCmd.Flags().BoolVar(&importOptions.FailOnEmpty, "fail-on-empty", false, "Exit with an error if no files were found or no rows were imported")
+
+	statsCmd.Flags().StringArrayVarP(&statsRequestFilter, "filter", "f", nil, "Regex pattern to filter requests (repeatable, all must match)")
+	statsCmd.Flags().StringArrayVar(&statsExclude, "exclude", nil, "Regex pattern to exclude requests (repeatable)")
+	statsCmd.Flags().StringVar(&statsInterval, "interval", "minute", "Time bucket to group stats by: 'second', 'minute', 'hour', or 'day'")
+	statsCmd.Flags().StringVar(&statsFilterColumn, "filter-column", "request", "Column that --filter and --exclude patterns are matched against, e.g. user_agent or domain_name")
+	statsCmd.Flags().StringVar(&statsGroupBy, "group-by", "", "Group stats by the values of this column instead of by time, e.g. elb, domain_name, target or elb_status_code")
+	statsCmd.Flags().BoolVar(&statsSparkline, "sparkline", false, "Print a sparkline of the requests per time bucket after the table")
+	statsCmd.Flags().StringVar(&statsRulePriority, "rule-priority", "", "Only include requests matched by the listener rule with this priority")
+	statsCmd.Flags().StringVar(&statsAction, "action", "", "Only include requests whose executed actions contain this action, e.g. redirect or fixed-response")
+
+	for _, cmd := range []*cobra.Command{statsCmd, errorsCmd} {
+		cmd.Flags().StringVar(&targetStatusCode, "target-status-code", "", "Only include requests a target answered with this status code, including any of the targets in target_status_code_list")
+	}
+	for _, cmd := range []*cobra.Command{statsCmd, tlsCmd} {
+		cmd.Flags().StringVar(&sslCipherFilter, "ssl-cipher", "", "Regex pattern to filter requests by negotiated SSL cipher")
+	}
+	pruneCmd.Flags().StringVar(&pruneBefore, "before", "", "Delete requests logged before this time (e.g. 2024-01-02, 2024-01-02 15:04 or RFC3339), required")
+	errorsCmd.Flags().StringArrayVarP(&statsRequestFilter, "filter", "f", nil, "Regex pattern to filter requests (repeatable, all must match)")
+	errorsCmd.Flags().StringArrayVar(&statsExclude, "exclude", nil, "Regex pattern to exclude requests (repeatable)")
+	errorsCmd.Flags().StringVar(&statusClass, "status-class", "", "Only show requests with an ELB or target status code of this class, e.g. 4xx or 5xx")
+	errorsCmd.Flags().IntVar(&errorsLimit, "limit", 100, "Number of failed requests to show (0 for all)")
+	latencyCmd.Flags().StringArrayVarP(&statsRequestFilter, "filter", "f", nil, "Regex pattern to filter requests (repeatable, all must match)")
+	latencyCmd.Flags().StringArrayVar(&statsExclude, "exclude", nil, "Regex pattern to exclude requests (repeatable)")
+	latencyCmd.Flags().StringVar(&statsInterval, "interval", "minute", "Time bucket to group latencies by: 'second', 'minute', 'hour', or 'day'")
+	clientsCmd.Flags().StringArrayVarP(&statsRequestFilter, "filter", "f", nil, "Regex pattern to filter requests (repeatable, all must match)")
+	clientsCmd.Flags().StringArrayVar(&statsExclude, "exclude", nil, "Regex pattern to exclude requests (repeatable)")
+	clientsCmd.Flags().IntVar(&clientsLimit, "limit", 20, "Number of client IPs to show (0 for all)")
+	clientsCmd.Flags().StringVar(&clientsFrom, "from", "", "Only count requests at or after this time (e.g. 2024-01-02, 2024-01-02 15:04 or RFC3339)")
+	clientsCmd.Flags().StringVar(&clientsTo, "to", "", "Only count requests before this time (e.g. 2024-01-02, 2024-01-02 15:04 or RFC3339)")
+	agentsCmd.Flags().StringArrayVarP(&statsRequestFilter, "filter", "f", nil, "Regex pattern to filter requests (repeatable, all must match)")
+	agentsCmd.Flags().StringArrayVar(&statsExclude, "exclude", nil, "Regex pattern to exclude requests (repeatable)")
+	agentsCmd.Flags().IntVar(&agentsLimit, "limit", 20, "Number of user agents to show (0 for all)")
+	agentsCmd.Flags().BoolVar(&normalizeAgents, "normalize", false, "Strip version numbers so that releases of the same client are grouped together")
+	tlsCmd.Flags().StringArrayVarP(&statsRequestFilter, "filter", "f", nil, "Regex pattern to filter requests (repeatable, all must match)")
+	tlsCmd.Flags().StringArrayVar(&statsExclude, "exclude", nil, "Regex pattern to exclude requests (repeatable)")
+
+	for _, cmd := range []*cobra.Command{queryCmd, sampleCmd, statsCmd, diffCmd, tlsCmd, agentsCmd, errorsCmd, clientsCmd, latencyCmd, traceCmd, describeCmd, fieldsCmd} {
+		cmd.Flags().StringVar(&outputFormat, "format", "table", "Output format: 'table', 'csv', 'json', 'markdown', or 'html'")
+		cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Write results to this file instead of stdout")
+		cmd.Flags().BoolVar(&gzipOutput, "gzip", false, "Compress the output file with gzip (implied by a .gz output path)")
+		cmd.Flags().BoolVar(&appendOutput, "append", false, "Append to the --output file instead of overwriting it, the CSV header is omitted if the file isn't empty and JSON is written as one object per line")
+		cmd.Flags().StringSliceVar(&selectedColumns, "columns", nil, "Comma-separated list of result columns to display, e.g. type,time,request")
+		cmd.Flags().StringVar(&sortBy, "sort", "", "Sort the results by a result column, append ':desc' for descending order, e.g. avg_response_time:desc")
+		cmd.Flags().StringVar(&pagerMode, "pager", "auto", "Page output through $PAGER (or 'less -FRSX'): 'auto' when stdout is a terminal, 'always', or 'never'")
+		cmd.Flags().StringVar(&nullString, "null-string", "", "Placeholder for NULL values (default 'NULL' in table and HTML, empty in CSV; JSON always uses null)")
+		cmd.Flags().BoolVar(&noHeader, "no-header", false, "Omit the header row in table and CSV output")
+		cmd.Flags().BoolVar(&noOptimize, "no-optimize", false, "Give all table columns the same width instead of fitting them to their content, for layouts that are stable across runs")
+		cmd.Flags().BoolVar(&showTotals, "total", false, "Add a footer row with the sum of each numeric column (table format only)")
+	}
+
+	histCmd.Flags().StringVarP(&histRequestFilter, "filter", "f", "", "Regex pattern to filter requests")
+	histCmd.Flags().IntVar(&histBuckets, "buckets", 20, "Number of histogram buckets")
+	histCmd.Flags().BoolVar(&histLogScale, "log", false, "Use logarithmic bucket sizes for long-tailed data (ignores values <= 0)")
+
+	queryCmd.Flags().BoolVar(&showSQL, "show-sql", false, "Print the SQL after the table name was rewritten for the active session to stderr before running it")
+	queryCmd.Flags().BoolVar(&noCache, "no-cache", false, "Run the query even if a cached result for it exists and don't cache its result")
+	queryCmd.Flags().BoolVar(&csvHeaderOnly, "csv-header-only", false, "Print the CSV header of the query's result columns without reading any rows")
+	queryCmd.Flags().BoolVar(&withTypes, "with-types", false, "With --csv-header-only, print the DuckDB type of each column in a second line")
+	queryCmd.Flags().DurationVar(&watchInterval, "watch", 0, "Run the query again every interval (e.g. 5s) and redraw the screen with its results until Ctrl-C")
+	queryCmd.Flags().BoolVar(&explainAnalyze, "explain-analyze", false, "Run the query with profiling and print the executed plan with timings instead of the results")
 
-	importCmd.Flags().StringVarP(&bucket, "bucket", "b", "", "S3 bucket name")
-	importCmd.Flags().StringVarP(&prefix, "prefix", "p", "", "S3 prefix (folder path) for ALB logs")
-	importCmd.Flags().StringVarP(&downloadDir, "download-dir", "d", "./logs", "Local directory to store downloaded logs")
+	diffCmd.Flags().StringSliceVar(&diffMetrics, "metric", nil, "Metrics to compare: "+strings.Join(db.DiffMetrics, ", ")+" (defaults to all)")
 
-	statsCmd.Flags().StringVarP(&statsRequestFilter, "filter", "f", "", "Regex pattern to filter requests")
+	sampleCmd.Flags().IntVarP(&sampleSize, "n", "n", 10, "Number of rows to sample")
 
-	rootCmd.AddCommand(sessionCmd, importCmd, queryCmd, statsCmd, fieldsCmd)
+	countCmd.Flags().StringVarP(&countRequestFilter, "filter", "f", "", "Regex pattern to filter requests")
+
+	rootCmd.AddCommand(sessionCmd, importCmd, queryCmd, sampleCmd, statsCmd, diffCmd, tlsCmd, agentsCmd, errorsCmd, clientsCmd, latencyCmd, countCmd, histCmd, traceCmd, describeCmd, fieldsCmd, validateCmd, cacheCmd, pruneCmd, benchCmd)
 }
 
 var sessionCmd = &cobra.Command{
-	Use:   "session [create|attach|list|kill]",
-	Short: "Manage sessions (create, attach, list, kill)",
+	Use:   "session [create|attach|list|kill|export|import|config|gc]",
+	Short: "Manage sessions (create, attach, list, kill, export, import, config, gc)",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if inMemory {
+			fmt.Fprintln(os.Stderr, "Sessions are not available in in-memory mode")
+			return
+		}
 		action := args[0]
 		switch action {
 		case "create":
 			if len(args) < 2 {
-				fmt.Println("Session name is required for 'create'")
+				fmt.Fprintln(os.Stderr, "Session name is required for 'create'")
+				return
+			}
+			tableType, err := db.ParseLogType(logType)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error creating session:", err)
 				return
 			}
 			wd, err := os.Getwd()
 			if err != nil {
-				fmt.Printf("Error creating session: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Error creating session: %v\n", err)
 				return
 			}
 			dbPath := fmt.Sprintf("%s/logwarts.duckdb", wd)
 			if err := session.CreateSession(args[1], dbPath); err != nil {
-				fmt.Println("Error creating session:", err)
+				fmt.Fprintln(os.Stderr, "Error creating session:", err)
 			}
 
 			dbConn, err := db.Connect(dbPath)
 			if err != nil {
-				fmt.Printf("Failed to connect to db: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to connect to db: %v\n", err)
 				return
 			}
 			defer dbConn.Close()
-			err = db.InitializeLogTable(dbConn)
+			err = db.InitializeLogTable(dbConn, tableType)
 			if err != nil {
-				fmt.Printf("Failed to initialize log table: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to initialize log table: %v\n", err)
 				return
 			}
 		case "attach":
 			if len(args) < 2 {
-				fmt.Println("Session name is required for 'attach'")
+				fmt.Fprintln(os.Stderr, "Session name is required for 'attach'")
 				return
 			}
 			if err := session.AttachSession(args[1]); err != nil {
-				fmt.Println("Error attaching to session:", err)
+				fmt.Fprintln(os.Stderr, "Error attaching to session:", err)
+				return
+			}
+
+			sess, err := session.GetActiveSession()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to get active session: %v\n", err)
+				return
+			}
+			dbConn, err := db.Connect(sess.DBPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to connect to db: %v\n", err)
+				return
+			}
+			defer dbConn.Close()
+			if err := db.Migrate(dbConn, sess); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to migrate log table: %v\n", err)
+				return
 			}
 		case "list":
 			sessions, err := session.ListSessions()
 			if err != nil {
-				fmt.Println("Error listing sessions:", err)
+				fmt.Fprintln(os.Stderr, "Error listing sessions:", err)
 				return
 			}
 			if len(sessions) < 1 {
@@ -115,303 +408,2202 @@ var sessionCmd = &cobra.Command{
 		case "kill":
 			sess, err := session.GetActiveSession()
 			if err != nil {
-				fmt.Printf("Failed to get active session: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to get active session: %v\n", err)
 				return
 			}
 			dbConn, err := db.Connect(sess.DBPath)
 			if err != nil {
-				fmt.Printf("Failed to connect to db: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to connect to db: %v\n", err)
 				return
 			}
 			defer dbConn.Close()
 
 			err = db.DeleteLogs(dbConn)
 			if err != nil {
-				fmt.Println("Error killing session's logs:", err)
+				fmt.Fprintln(os.Stderr, "Error killing session's logs:", err)
 				return
 			}
 			err = session.KillSession()
 			if err != nil {
-				fmt.Println("Error killing current session:", err)
+				fmt.Fprintln(os.Stderr, "Error killing current session:", err)
 				return
 			}
-		default:
-			fmt.Println("Unknown session command. Use 'create', 'attach', 'list', or 'kill'")
-		}
-	},
-}
-
-var importCmd = &cobra.Command{
-	Use:   "import [log file]",
-	Short: "Import ALB logs",
-	Run: func(cmd *cobra.Command, args []string) {
-		if source == "s3" {
-			if bucket == "" || prefix == "" || downloadDir == "" {
-				fmt.Println("Bucket, prefix, and download-dir are required flags for importing from S3")
+		case "export":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "File name is required for 'export'")
 				return
 			}
-
-			s3Client, err := s3.NewS3Client()
+			file, err := os.Create(args[1])
 			if err != nil {
-				fmt.Printf("Failed to create S3 client: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to create export file: %v\n", err)
 				return
 			}
-
-			err = s3Client.DownloadLogs(bucket, prefix, downloadDir)
-			if err != nil {
-				fmt.Printf("Failed to download logs: %v\n", err)
+			defer file.Close()
+			if err := session.ExportSessions(file); err != nil {
+				fmt.Fprintln(os.Stderr, "Error exporting sessions:", err)
 				return
 			}
-
-			sess, err := session.GetActiveSession()
-			if err != nil {
-				fmt.Printf("Failed to get active session: %v\n", err)
+		case "import":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "File name is required for 'import'")
 				return
 			}
-			dbConn, err := db.Connect(sess.DBPath)
+			file, err := os.Open(args[1])
 			if err != nil {
-				fmt.Printf("Failed to connect to db: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to open import file: %v\n", err)
 				return
 			}
-			defer dbConn.Close()
-
-			files, err := os.ReadDir(downloadDir)
+			defer file.Close()
+			imported, err := session.ImportSessions(file)
 			if err != nil {
-				fmt.Printf("Failed to read download directory: %v\n", err)
+				fmt.Fprintln(os.Stderr, "Error importing sessions:", err)
 				return
 			}
-			fileCount := 0
-			for _, file := range files {
-				if !file.IsDir() && (strings.HasSuffix(file.Name(), ".log") || strings.HasSuffix(file.Name(), ".log.gz")) {
-					fileCount++
+			for _, sess := range imported {
+				if _, err := os.Stat(sess.DBPath); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: log db of session '%s' not found at %s\n", sess.Name, sess.DBPath)
 				}
 			}
-
-			bar := progressbar.Default(int64(fileCount), "Importing logs from S3")
-			err = db.ImportDirectoryLogs(dbConn, downloadDir, func(current, total int) {
-				bar.Set(current)
-			})
+			logger.Infof("Imported %d session(s)\n", len(imported))
+		case "config":
+			sess, err := session.GetActiveSession()
 			if err != nil {
-				fmt.Printf("\nFailed to import logs from directory: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to get active session: %v\n", err)
 				return
 			}
-			fmt.Printf("\nSuccessfully imported %d file(s) from S3 to db\n", fileCount)
-
-		} else if source == "local" {
-			var files []string
-			s := bufio.NewScanner(os.Stdin)
-			for s.Scan() {
-				filename := strings.TrimSpace(s.Text())
-				if filename != "" {
-					files = append(files, filename)
+			if len(args) < 2 || args[1] == "show" {
+				defaults := map[string]string{
+					"filter":   sess.Defaults.Filter,
+					"exclude":  sess.Defaults.Exclude,
+					"format":   sess.Defaults.Format,
+					"interval": sess.Defaults.Interval,
 				}
+				for _, key := range session.DefaultKeys() {
+					fmt.Printf("%s = %s\n", key, defaults[key])
+				}
+				return
 			}
-			if err := s.Err(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading from stdin: %v\n", err)
-				os.Exit(1)
+			if args[1] != "set" || len(args) != 4 {
+				fmt.Fprintln(os.Stderr, "Usage: logwarts session config [show|set <key> <value>]")
+				return
 			}
-
-			sess, err := session.GetActiveSession()
-			if err != nil {
-				fmt.Printf("Failed to get active session: %v\n", err)
+			if err := validateSessionDefault(args[2], args[3]); err != nil {
+				fmt.Fprintln(os.Stderr, "Error setting session option:", err)
 				return
 			}
-			dbConn, err := db.Connect(sess.DBPath)
-			if err != nil {
-				fmt.Printf("Failed to connect to db: %v\n", err)
+			if err := session.SetDefault(args[2], args[3]); err != nil {
+				fmt.Fprintln(os.Stderr, "Error setting session option:", err)
 				return
 			}
-			defer dbConn.Close()
+			logger.Infof("Set %s of session '%s' to '%s'\n", args[2], sess.Name, args[3])
+		case "gc":
+			if err := collectSessionGarbage(pruneOrphans); err != nil {
+				fmt.Fprintln(os.Stderr, "Error collecting orphaned sessions:", err)
+				return
+			}
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown session command. Use 'create', 'attach', 'list', 'kill', 'export', 'import', 'config', or 'gc'")
+		}
+	},
+}
 
-			bar := progressbar.Default(int64(len(files)), "Importing logs")
-			successCount := 0
-			for _, filePath := range files {
-				err := db.ImportLogFile(dbConn, filePath)
-				if err != nil {
-					fmt.Printf("\nFailed to import file '%s': %v\n", filePath, err)
-					bar.Add(1)
-					continue
+// collectSessionGarbage reports sessions whose log db is gone and log tables without a session,
+// and removes them if prune is set
+func collectSessionGarbage(prune bool) error {
+	sessions, err := session.ListSessions()
+	if err != nil {
+		return err
+	}
+
+	orphans := 0
+	byPath := make(map[string][]session.Session)
+	var paths []string
+	for _, sess := range sessions {
+		if _, err := os.Stat(sess.DBPath); err != nil {
+			orphans++
+			fmt.Printf("Session '%s': log db %s not found\n", sess.Name, sess.DBPath)
+			if prune {
+				if err := session.DeleteSession(sess.Name); err != nil {
+					return err
 				}
-				successCount++
-				bar.Add(1)
 			}
-			fmt.Printf("\nSuccessfully imported %d/%d file(s)\n", successCount, len(files))
+			continue
+		}
+		if _, ok := byPath[sess.DBPath]; !ok {
+			paths = append(paths, sess.DBPath)
+		}
+		byPath[sess.DBPath] = append(byPath[sess.DBPath], sess)
+	}
 
-		} else {
-			fmt.Println("Invalid source specified. Use 's3' or 'local'.")
+	for _, path := range paths {
+		dbConn, err := db.Connect(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+			continue
 		}
+		tables, err := db.OrphanedTables(dbConn, byPath[path])
+		if err == nil && prune {
+			for _, table := range tables {
+				if err = db.DropOrphanedTable(dbConn, table); err != nil {
+					break
+				}
+			}
+		}
+		dbConn.Close()
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			orphans++
+			fmt.Printf("Table %s in %s: no session\n", table, path)
+		}
+	}
+
+	switch {
+	case orphans == 0:
+		fmt.Println("No orphaned sessions or tables found")
+	case prune:
+		logger.Infof("Removed %d orphaned session(s) and table(s)\n", orphans)
+	default:
+		logger.Infof("Found %d orphaned session(s) and table(s), run 'logwarts session gc --prune' to remove them\n", orphans)
+	}
+	return nil
+}
+
+var importCmd = &cobra.Command{
+	Use:          "import [log file]",
+	Short:        "Import ALB logs",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if inMemory {
+			return fmt.Errorf("Imports are not persisted in in-memory mode, pipe log files into 'query' or 'stats' instead")
+		}
+		// Stop starting new downloads and imports on Ctrl-C, a second Ctrl-C terminates immediately
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+
+		return runImport(ctx, importOptions)
 	},
 }
 
-var queryCmd = &cobra.Command{
-	Use:   "query [SQL]",
-	Short: "Run a SQL query against database",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		activeSessions, err := session.GetActiveSession()
-		if err != nil {
-			fmt.Errorf("Failed to get active session for import: %v", err)
-			os.Exit(1)
+// runImport imports logs from S3 or local files into the active session as configured by opts
+func runImport(ctx context.Context, opts ImportOptions) error {
+	importType, err := db.ParseLogType(opts.LogType)
+	if err != nil {
+		return err
+	}
+	if opts.Progress != "bar" && opts.Progress != "json" {
+		return fmt.Errorf("Invalid progress mode '%s'. Use 'bar' or 'json'", opts.Progress)
+	}
+
+	copyOptions, err := db.ParseCopyOptions(opts.Delimiter, opts.Quote, opts.Escape, opts.Null)
+	if err != nil {
+		return err
+	}
+	importFile := db.LogFileImporter(copyOptions)
+	switch opts.Format {
+	case "log":
+	case "jsonl":
+		if opts.Source != "local" {
+			return fmt.Errorf("JSON lines can only be imported from local files, use --source=local")
 		}
-		tableName := fmt.Sprintf("alb_logs_%s", activeSessions.Name)
+		importFile = db.ImportJSONFile
+	default:
+		return fmt.Errorf("Invalid import format '%s'. Use 'log' or 'jsonl'", opts.Format)
+	}
 
-		sqlQuery := strings.Replace(args[0], "alb_logs", tableName, 1)
+	if opts.Source == "s3" {
+		// The inventory lists the objects, so the prefix only narrows them down
+		if opts.Bucket == "" || (opts.Prefix == "" && opts.PrefixTemplate == "" && opts.Manifest == "") || opts.DownloadDir == "" {
+			return fmt.Errorf("Bucket, prefix, and download-dir are required flags for importing from S3")
+		}
 
-		sess, err := session.GetActiveSession()
-		if err != nil {
-			fmt.Printf("Failed to get active session: %v\n", err)
-			return
+		if err := s3.ValidateRateLimit(opts.RateLimit); err != nil {
+			return err
 		}
-		dbConn, err := db.Connect(sess.DBPath)
-		if err != nil {
-			fmt.Printf("Failed to connect to db: %v\n", err)
-			os.Exit(1)
+		if opts.Cleanup && opts.Keep {
+			return fmt.Errorf("--cleanup and --keep can't be combined")
 		}
-		defer dbConn.Close()
 
-		rows, err := db.ExecuteQuery(dbConn, sqlQuery)
+		timeRange, err := parseTimeRange(opts.Since, opts.Until)
 		if err != nil {
-			fmt.Printf("Failed to execute query: %v\n", err)
-			os.Exit(1)
+			return err
+		}
+		if opts.SinceLastImport {
+			if opts.Since != "" {
+				return fmt.Errorf("--since and --since-last-import can't be combined")
+			}
+			// Objects at exactly the last timestamp are listed again but skipped by their unchanged ETag
+			timeRange.Since, err = lastImportTime()
+			if err != nil {
+				return err
+			}
+			if timeRange.Since.IsZero() {
+				logger.Infof("No S3 objects imported into this session yet, importing everything\n")
+			} else {
+				logger.Infof("Importing S3 objects last modified at or after %s\n", timeRange.Since.Format(time.RFC3339))
+			}
+		}
+		prefixes := []string{opts.Prefix}
+		if opts.PrefixTemplate != "" {
+			if opts.Prefix != "" {
+				return fmt.Errorf("--prefix and --prefix-template can't be combined")
+			}
+			prefixes, err = s3.ExpandPrefixTemplate(opts.PrefixTemplate, timeRange)
+			if err != nil {
+				return fmt.Errorf("Invalid --prefix-template: %v", err)
+			}
+			logger.Debugf("Expanded prefix template to %d prefix(es): %s", len(prefixes), strings.Join(prefixes, ", "))
 		}
-		defer rows.Close()
 
-		err = displayResults(rows)
+		s3Client, err := s3.NewS3Client(opts.AWS)
 		if err != nil {
-			fmt.Printf("Failed to display results: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("Failed to create S3 client: %v", err)
+		}
+		s3Client.Verify = opts.Verify
+		s3Client.Decompress = opts.Decompress
+		s3Client.Manifest = opts.Manifest
+		s3Client.Concurrency = opts.DownloadWorkers
+		s3Client.RateLimit = opts.RateLimit
+
+		return importFromS3(ctx, opts, s3Client, prefixes, timeRange, importType, importFile)
+	} else if opts.Source == "local" {
+		if opts.StdinContent {
+			if opts.Glob != "" {
+				return fmt.Errorf("--glob and --stdin-content can't be combined")
+			}
+			return importStdinContent(opts, importType, importFile)
+		}
+
+		var files []string
+		if opts.Glob != "" {
+			files, err = glob.Expand(opts.Glob)
+			if err != nil {
+				return err
+			}
+			logger.Infof("Matched %d file(s) with '%s'\n", len(files), opts.Glob)
+		} else {
+			files, err = readFilenames(opts.Stdin)
+			if err != nil {
+				return fmt.Errorf("Error reading from stdin: %v", err)
+			}
 		}
-	},
-}
 
-var statsCmd = &cobra.Command{
-	Use:   "stats",
-	Short: "Show performance statistics",
-	Run: func(cmd *cobra.Command, args []string) {
 		sess, err := session.GetActiveSession()
 		if err != nil {
-			fmt.Printf("Failed to get active session: %v\n", err)
-			return
+			return fmt.Errorf("Failed to get active session: %w", err)
 		}
 		dbConn, err := db.Connect(sess.DBPath)
 		if err != nil {
-			fmt.Printf("Failed to connect to db: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("Failed to connect to db: %w", err)
 		}
 		defer dbConn.Close()
 
-		sanitizedFilter, err := sanitizeRegex(statsRequestFilter)
-		if err != nil {
-			fmt.Printf("Filter is not a valid regex pattern: %v", err)
-			os.Exit(1)
+		if err := db.Migrate(dbConn, sess); err != nil {
+			return err
 		}
-		stats, err := db.GetFilteredStats(dbConn, sanitizedFilter)
+		if err := db.CheckLogType(dbConn, importType); err != nil {
+			return err
+		}
+		before, err := db.SumTransferredBytes(dbConn, sess)
 		if err != nil {
-			fmt.Printf("Failed to retrieve stats: %v\n", err)
-			os.Exit(1)
+			return err
 		}
 
-		err = displayResults(stats)
+		imported, err := db.ImportedFiles(dbConn, sess)
 		if err != nil {
-			fmt.Printf("Failed to display results: %v\n", err)
-			os.Exit(1)
+			return err
+		}
+		var pending, alreadyImported []string
+		sizes := make(map[string]int64)
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err == nil && !opts.Force && db.IsImportedFile(imported, file, info.Size()) {
+				alreadyImported = append(alreadyImported, file)
+				continue
+			}
+			if err == nil {
+				sizes[file] = info.Size()
+			}
+			pending = append(pending, file)
 		}
-	},
-}
 
-var fieldsCmd = &cobra.Command{
-	Use:   "fields [list]",
-	Short: "Manage log fields available for queries (list)",
-	Args:  cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		action := args[0]
-		switch action {
-		case "list":
-			fields := []string{
-				"type",
-				"time",
-				"elb",
-				"client",
-				"target",
-				"request_processing_time",
-				"target_processing_time",
-				"response_processing_time",
-				"elb_status_code",
-				"target_status_code",
-				"received_bytes",
-				"sent_bytes",
-				"request",
-				"user_agent",
-				"ssl_cipher",
-				"ssl_protocol",
-				"target_group_arn",
-				"trace_id",
-				"domain_name",
-				"chosen_cert_arn",
-				"matched_rule_priority",
-				"request_creation_time",
-				"actions_executed",
-				"redirect_url",
-				"error_reason",
-				"target_port_list",
-				"target_status_code_list",
-				"classification",
-				"classification_reason",
-				"conn_trace_id",
-			}
-			for _, field := range fields {
-				fmt.Printf("%s\n", field)
+		// Record every imported file right away so that an interrupted import resumes where it stopped
+		recordingImport := func(dbConn *sql.DB, path string) (int64, error) {
+			rows, err := importFile(dbConn, path)
+			if err != nil {
+				return rows, err
 			}
-		default:
-			fmt.Println("Unknown session command. Use 'create', 'attach', 'list', or 'kill'")
+			return rows, db.RecordImportedFile(dbConn, sess, path, sizes[path])
 		}
-	},
+
+		bar := newProgress(opts.Progress, "import", len(pending), "Importing logs")
+		result := db.ImportFiles(ctx, dbConn, pending, opts.Workers, recordingImport, func(current, total int) {
+			bar.Set(current)
+		})
+		result.AlreadyImported = alreadyImported
+		if err := recordTransfer(dbConn, sess, result, before); err != nil {
+			return err
+		}
+		printImportSummary(result)
+		if ctx.Err() != nil {
+			return finishInterruptedImport(dbConn)
+		}
+		if err := enrichGeoIP(dbConn, opts.GeoIP); err != nil {
+			return err
+		}
+		return importError(result, opts)
+	}
+
+	return fmt.Errorf("Invalid source specified. Use 's3' or 'local'.")
 }
 
-func sanitizeRegex(pattern string) (string, error) {
-	_, err := regexp.Compile(pattern)
+// lastImportTime returns the newest LastModified of the S3 objects imported into the active session,
+// or the zero time before the first S3 import
+func lastImportTime() (time.Time, error) {
+	sess, err := session.GetActiveSession()
 	if err != nil {
-		return "", err
+		return time.Time{}, fmt.Errorf("Failed to get active session: %w", err)
 	}
-	return pattern, nil
+	dbConn, err := db.Connect(sess.DBPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to connect to db: %w", err)
+	}
+	defer dbConn.Close()
+	return db.LastImportedObjectTime(dbConn, sess)
 }
 
-func displayResults(rows *sql.Rows) error {
-	columns, err := rows.Columns()
+// importFromS3 downloads the logs source lists for the options' bucket and the prefixes and imports them into the active session
+func importFromS3(ctx context.Context, opts ImportOptions, source s3.LogSource, prefixes []string, timeRange s3.TimeRange, importType db.LogType, importFile func(*sql.DB, string) (int64, error)) error {
+	if opts.DryRun {
+		objects, err := source.ListLogs(opts.Bucket, prefixes, timeRange)
+		if err != nil {
+			return fmt.Errorf("Failed to list logs: %v", err)
+		}
+		var totalBytes int64
+		for _, object := range objects {
+			size := aws.ToInt64(object.Size)
+			totalBytes += size
+			fmt.Printf("%10s  %s\n", formatBytes(size), aws.ToString(object.Key))
+		}
+		fmt.Printf("%d object(s), %s total\n", len(objects), formatBytes(totalBytes))
+		if opts.FailOnEmpty && len(objects) == 0 {
+			return fmt.Errorf("No objects matched and --fail-on-empty is set")
+		}
+		return nil
+	}
+
+	sess, err := session.GetActiveSession()
 	if err != nil {
-		return fmt.Errorf("Failed to get columns: %v", err)
+		return fmt.Errorf("Failed to get active session: %w", err)
 	}
+	dbConn, err := db.Connect(sess.DBPath)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to db: %w", err)
+	}
+	defer dbConn.Close()
 
-	tbl := output.NewTable(columns)
+	if err := db.Migrate(dbConn, sess); err != nil {
+		return err
+	}
+	if err := db.CheckLogType(dbConn, importType); err != nil {
+		return err
+	}
+	before, err := db.SumTransferredBytes(dbConn, sess)
+	if err != nil {
+		return err
+	}
 
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-	for i := range values {
-		valuePtrs[i] = &values[i]
+	imported, err := db.ImportedObjects(dbConn, sess)
+	if err != nil {
+		return err
+	}
+	skip := func(object types.Object) bool {
+		etag, ok := imported[aws.ToString(object.Key)]
+		return ok && !opts.Full && etag == strings.Trim(aws.ToString(object.ETag), `"`)
 	}
 
-	for rows.Next() {
-		err := rows.Scan(valuePtrs...)
-		if err != nil {
-			return fmt.Errorf("Failed to scan row: %v", err)
-		}
+	var downloadBar *syncProgress
+	var downloadBarOnce sync.Once
+	download, err := source.DownloadLogs(ctx, opts.Bucket, prefixes, opts.DownloadDir, timeRange, skip, func(current, total int) {
+		downloadBarOnce.Do(func() {
+			downloadBar = newProgress(opts.Progress, "download", total, "Downloading logs from S3")
+		})
+		downloadBar.Set(current)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to download logs: %v", err)
+	}
+	printDownloadSummary(download, opts.DownloadDir)
 
-		row := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				row[i] = "NULL"
-			} else {
-				row[i] = fmt.Sprintf("%v", val)
+	bar := newProgress(opts.Progress, "import", len(download.Downloaded), "Importing logs from S3")
+	result := &db.ImportResult{}
+	var importedPaths []string
+	for i, object := range download.Downloaded {
+		if ctx.Err() != nil {
+			break
+		}
+		result.DownloadedBytes += object.Size
+		if !strings.HasSuffix(object.Path, ".log") && !strings.HasSuffix(object.Path, ".log.gz") {
+			result.Skipped = append(result.Skipped, object.Path)
+		} else {
+			rows, err := importFile(dbConn, object.Path)
+			if err == nil {
+				err = db.RecordImportedObject(dbConn, sess, object.Key, object.ETag, object.LastModified)
+			}
+			if err == nil {
+				importedPaths = append(importedPaths, object.Path)
 			}
+			result.Add(object.Path, rows, err)
 		}
-		tbl.AddRow(row)
+		bar.Set(i + 1)
+	}
+	if err := recordTransfer(dbConn, sess, result, before); err != nil {
+		return err
+	}
+	printImportSummary(result)
+	if opts.Cleanup {
+		removeDownloads(importedPaths)
 	}
+	if ctx.Err() != nil {
+		return finishInterruptedImport(dbConn)
+	}
+	if err := enrichGeoIP(dbConn, opts.GeoIP); err != nil {
+		return err
+	}
+	return importError(result, opts)
+}
 
-	if err = rows.Err(); err != nil {
-		return fmt.Errorf("Error during rows iteration: %v", err)
+// removeDownloads deletes downloaded files that were imported and reports the disk space freed,
+// files that can't be removed are reported but don't fail the import
+func removeDownloads(paths []string) {
+	var freed int64
+	removed := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Errorf("Failed to remove downloaded file '%s': %v\n", path, err)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Errorf("Failed to remove downloaded file '%s': %v\n", path, err)
+			continue
+		}
+		freed += info.Size()
+		removed++
+	}
+	fmt.Printf("Removed %d downloaded file(s), freed %s\n", removed, formatBytes(freed))
+}
+
+// watchQuery runs the query every interval on the open connection and redraws the terminal with its results
+// until Ctrl-C, failed runs are shown like results so that a transient error doesn't end the watch
+func watchQuery(ctx context.Context, dbConn *sql.DB, sqlQuery string, interval time.Duration) error {
+	if outputPath != "" {
+		return fmt.Errorf("--watch writes to the terminal and can't be combined with --output")
 	}
+	// A pager would wait for the user on every refresh
+	pagerMode = "never"
 
-	tbl.Render()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		columns, records, err := runQuery(dbConn, sqlQuery)
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("Every %s: %s  (%s, took %s)\n\n", interval, sqlQuery, start.Format(time.TimeOnly), time.Since(start).Round(time.Millisecond))
+		if err == nil {
+			err = renderResults(columns, records)
+		}
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+		}
 
-	return nil
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func runQuery(dbConn *sql.DB, sqlQuery string) ([]string, [][]interface{}, error) {
+	rows, err := db.ExecuteQuery(dbConn, sqlQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	return scanResults(rows)
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query [SQL]",
+	Short: "Run a SQL query against database",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sess, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+		applySessionDefaults(cmd, sess)
+
+		sqlQuery, err := db.RewriteQuery(args[0], sess)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if showSQL {
+			fmt.Fprintln(os.Stderr, sqlQuery)
+		}
+
+		if csvHeaderOnly {
+			if err := printCSVHeader(dbConn, sqlQuery); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if watchInterval > 0 {
+			if err := watchQuery(cmd.Context(), dbConn, sqlQuery, watchInterval); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if explainAnalyze {
+			start := time.Now()
+			plan, err := db.ExplainAnalyze(dbConn, sqlQuery)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Println(plan)
+			fmt.Printf("Total wall time: %s\n", time.Since(start).Round(time.Millisecond))
+			return
+		}
+
+		start := time.Now()
+		cacheKey := ""
+		if !noCache && !sess.InMemory() && isReadOnlyQuery(sqlQuery) {
+			cacheKey, err = queryCacheKey(dbConn, sess, sqlQuery)
+			if err != nil {
+				logger.Debugf("Not caching query: %v", err)
+			}
+		}
+		if cacheKey != "" {
+			if cached, ok := cache.Load(cacheKey); ok {
+				logger.Debugf("Using cached result %s", cacheKey)
+				if err := renderResults(cached.Columns, cached.Rows); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+					os.Exit(1)
+				}
+				return
+			}
+		}
+
+		rows, err := db.ExecuteQuery(dbConn, sqlQuery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to execute query: %v\n", err)
+			os.Exit(1)
+		}
+		defer rows.Close()
+
+		if bufferResults() {
+			columns, records, err := scanResults(rows)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+				os.Exit(1)
+			}
+			logger.Debugf("Query finished in %s", time.Since(start).Round(time.Millisecond))
+			storeCachedResult(cacheKey, columns, records)
+			if err := renderResults(columns, records); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		// Streamed rows are only kept for the cache as long as the result is small
+		columns, err := rows.Columns()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get columns: %v\n", err)
+			os.Exit(1)
+		}
+		var records [][]interface{}
+		cacheable := cacheKey != ""
+		collect := func(record []interface{}) {
+			if !cacheable {
+				return
+			}
+			if len(records) >= maxCachedRows {
+				logger.Debugf("Not caching query: more than %d rows", maxCachedRows)
+				cacheable, records = false, nil
+				return
+			}
+			records = append(records, record)
+		}
+		if err := streamResults(rows, collect); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+		logger.Debugf("Query finished in %s", time.Since(start).Round(time.Millisecond))
+		if cacheable {
+			storeCachedResult(cacheKey, columns, records)
+		}
+	},
+}
+
+// Larger results are streamed without caching them, so that they are never held in memory
+const maxCachedRows = 100000
+
+func storeCachedResult(cacheKey string, columns []string, records [][]interface{}) {
+	if cacheKey == "" {
+		return
+	}
+	if err := cache.Store(cacheKey, &cache.Result{Columns: columns, Rows: records}); err != nil {
+		logger.Debugf("Failed to cache result: %v", err)
+	}
+}
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample",
+	Short: "Show a random sample of rows from the active session",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		rows, err := db.SampleRows(dbConn, sampleSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to sample rows: %v\n", err)
+			os.Exit(1)
+		}
+		defer rows.Close()
+
+		err = displayResults(rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show performance statistics",
+	Run: func(cmd *cobra.Command, args []string) {
+		sess, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+		applySessionDefaults(cmd, sess)
+
+		if statsSparkline && statsGroupBy != "" {
+			fmt.Fprintln(os.Stderr, "--sparkline draws requests over time and can't be combined with --group-by")
+			os.Exit(1)
+		}
+		if statsSparkline && outputFormat != "table" {
+			fmt.Fprintln(os.Stderr, "--sparkline can only be used with --format table")
+			os.Exit(1)
+		}
+
+		filters, err := sanitizeRegexes(statsRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		excludes, err := sanitizeRegexes(statsExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Exclude is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		cipher, err := sanitizeRegex(sslCipherFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SSL cipher is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		stats, err := db.GetFilteredStats(dbConn, db.StatsFilter{
+			Requests:            filters,
+			Excludes:            excludes,
+			FilterColumn:        statsFilterColumn,
+			MatchedRulePriority: statsRulePriority,
+			Action:              statsAction,
+			SSLCipher:           cipher,
+			TargetStatusCode:    targetStatusCode,
+		}, statsInterval, statsGroupBy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to retrieve stats: %v\n", err)
+			os.Exit(1)
+		}
+		defer stats.Close()
+
+		columns, records, err := scanResults(stats)
+		if err == nil {
+			// Read the counts before --sort reorders the records
+			var counts []int64
+			if statsSparkline {
+				counts = bucketCounts(columns, records, statsInterval)
+			}
+			err = renderResults(columns, records)
+			if err == nil && statsSparkline {
+				printSparkline(counts, statsInterval)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// Filling gaps would need too much memory beyond this many buckets, e.g. a year by the second
+const maxSparklineBuckets = 1000000
+
+var intervalDurations = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// bucketCounts returns the requests of every time bucket in the stats records in time order,
+// buckets without requests are missing from the records and counted as 0
+func bucketCounts(columns []string, records [][]interface{}, interval string) []int64 {
+	timeIndex := slices.Index(columns, interval)
+	countIndex := slices.Index(columns, "requests")
+	if timeIndex < 0 || countIndex < 0 || len(records) == 0 {
+		return nil
+	}
+
+	step := intervalDurations[interval]
+	var counts []int64
+	var previous time.Time
+	for _, record := range records {
+		bucket, _ := record[timeIndex].(time.Time)
+		count, _ := record[countIndex].(int64)
+		if !previous.IsZero() && !bucket.IsZero() {
+			gap := int(bucket.Sub(previous)/step) - 1
+			if gap > 0 && len(counts)+gap <= maxSparklineBuckets {
+				counts = append(counts, make([]int64, gap)...)
+			}
+		}
+		counts = append(counts, count)
+		previous = bucket
+	}
+	return counts
+}
+
+func printSparkline(counts []int64, interval string) {
+	if len(counts) == 0 {
+		return
+	}
+	line, perChar := output.Sparkline(counts, output.TerminalWidth(), !output.SupportsUnicode())
+	peak := slices.Max(counts)
+	fmt.Printf("\nRequests per %s, peak %s", interval, formatCount(peak))
+	if perChar > 1 {
+		fmt.Printf(", %d %ss per character", perChar, interval)
+	}
+	fmt.Printf(":\n%s\n", line)
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [session-a] [session-b]",
+	Short: "Compare request count, error rate and latency of two sessions",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if inMemory {
+			fmt.Fprintln(os.Stderr, "Sessions are not available in in-memory mode")
+			os.Exit(1)
+		}
+		base, err := session.GetSession(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		other, err := session.GetSession(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		dbConn, err := db.ConnectReadOnly(base.DBPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to db: %v\n", err)
+			os.Exit(1)
+		}
+		defer dbConn.Close()
+
+		rows, err := db.DiffSessions(dbConn, base, other, diffMetrics)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compare sessions: %v\n", err)
+			os.Exit(1)
+		}
+		defer rows.Close()
+
+		err = displayResults(rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var tlsCmd = &cobra.Command{
+	Use:   "tls",
+	Short: "Show request counts per SSL protocol and cipher, flagging deprecated protocols",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		filters, err := sanitizeRegexes(statsRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		excludes, err := sanitizeRegexes(statsExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Exclude is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		cipher, err := sanitizeRegex(sslCipherFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SSL cipher is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		report, err := db.GetTLSReport(dbConn, db.StatsFilter{
+			Requests:  filters,
+			Excludes:  excludes,
+			SSLCipher: cipher,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to retrieve TLS report: %v\n", err)
+			os.Exit(1)
+		}
+		defer report.Close()
+
+		err = displayResults(report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var agentsCmd = &cobra.Command{
+	Use:   "agents",
+	Short: "Show the top clients by user agent with request counts and bytes",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		filters, err := sanitizeRegexes(statsRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		excludes, err := sanitizeRegexes(statsExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Exclude is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		agents, total, err := db.GetUserAgents(dbConn, db.StatsFilter{
+			Requests: filters,
+			Excludes: excludes,
+		}, agentsLimit, normalizeAgents)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to retrieve user agents: %v\n", err)
+			os.Exit(1)
+		}
+		defer agents.Close()
+
+		err = displayResults(agents)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+		printTruncation(agentsLimit, total)
+	},
+}
+
+var errorsCmd = &cobra.Command{
+	Use:   "errors",
+	Short: "List the most recent requests that failed at the load balancer or the target",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		filters, err := sanitizeRegexes(statsRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		excludes, err := sanitizeRegexes(statsExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Exclude is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		failed, total, err := db.GetFailedRequests(dbConn, db.StatsFilter{
+			Requests:         filters,
+			Excludes:         excludes,
+			TargetStatusCode: targetStatusCode,
+		}, statusClass, errorsLimit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to retrieve failed requests: %v\n", err)
+			os.Exit(1)
+		}
+		defer failed.Close()
+
+		err = displayResults(failed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+		printTruncation(errorsLimit, total)
+	},
+}
+
+var latencyCmd = &cobra.Command{
+	Use:   "latency",
+	Short: "Show min, avg and p95 of the request, target and response processing times",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		filters, err := sanitizeRegexes(statsRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		excludes, err := sanitizeRegexes(statsExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Exclude is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+
+		latency, err := db.GetLatency(dbConn, db.StatsFilter{
+			Requests: filters,
+			Excludes: excludes,
+		}, statsInterval)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to retrieve latency: %v\n", err)
+			os.Exit(1)
+		}
+		defer latency.Close()
+
+		err = displayResults(latency)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var clientsCmd = &cobra.Command{
+	Use:   "clients",
+	Short: "Show the top client IPs by request count with distinct paths and error rate",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		filters, err := sanitizeRegexes(statsRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		excludes, err := sanitizeRegexes(statsExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Exclude is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		filter := db.StatsFilter{
+			Requests: filters,
+			Excludes: excludes,
+		}
+		if clientsFrom != "" {
+			if filter.From, err = timeutil.ParseTimeFlexible(clientsFrom); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --from: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if clientsTo != "" {
+			if filter.To, err = timeutil.ParseTimeFlexible(clientsTo); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --to: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		clients, total, err := db.GetClients(dbConn, filter, clientsLimit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to retrieve clients: %v\n", err)
+			os.Exit(1)
+		}
+		defer clients.Close()
+
+		err = displayResults(clients)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+		printTruncation(clientsLimit, total)
+	},
+}
+
+var pruneCmd = &cobra.Command{
+	Use:          "prune",
+	Short:        "Delete requests older than a point in time from the active session",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if inMemory {
+			return fmt.Errorf("There is nothing to prune in in-memory mode")
+		}
+		// Without a time every row would match, so it has to be given explicitly
+		if pruneBefore == "" {
+			return fmt.Errorf("--before is required")
+		}
+		before, err := timeutil.ParseTimeFlexible(pruneBefore)
+		if err != nil {
+			return fmt.Errorf("Invalid --before: %v", err)
+		}
+
+		sess, err := session.GetActiveSession()
+		if err != nil {
+			return fmt.Errorf("Failed to get active session: %w", err)
+		}
+		dbConn, err := db.Connect(sess.DBPath)
+		if err != nil {
+			return fmt.Errorf("Failed to connect to db: %w", err)
+		}
+		defer dbConn.Close()
+
+		deleted, err := db.PruneBefore(dbConn, before)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d row(s) logged before %s\n", deleted, before.UTC().Format(time.RFC3339))
+		return nil
+	},
+}
+
+var countCmd = &cobra.Command{
+	Use:   "count",
+	Short: "Print the number of requests matching a filter",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		sanitizedFilter, err := sanitizeRegex(countRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		count, err := db.CountRequests(dbConn, sanitizedFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to count requests: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(count)
+	},
+}
+
+var histCmd = &cobra.Command{
+	Use:   "hist [column]",
+	Short: "Show the distribution of a numeric column as a histogram",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		sanitizedFilter, err := sanitizeRegex(histRequestFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Filter is not a valid regex pattern: %v\n", err)
+			os.Exit(1)
+		}
+		buckets, err := db.GetHistogram(dbConn, args[0], sanitizedFilter, histBuckets, histLogScale)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compute histogram: %v\n", err)
+			os.Exit(1)
+		}
+		if len(buckets) == 0 {
+			fmt.Fprintf(os.Stderr, "No values found for '%s'\n", args[0])
+			return
+		}
+
+		labels := make([]string, len(buckets))
+		counts := make([]int64, len(buckets))
+		for i, bucket := range buckets {
+			labels[i] = fmt.Sprintf("[%.4g, %.4g)", bucket.Lower, bucket.Upper)
+			counts[i] = bucket.Count
+		}
+		labels[len(labels)-1] = fmt.Sprintf("[%.4g, %.4g]", buckets[len(buckets)-1].Lower, buckets[len(buckets)-1].Upper)
+		output.WriteBarChart(labels, counts, os.Stdout)
+	},
+}
+
+var traceCmd = &cobra.Command{
+	Use:   "trace [trace-id]",
+	Short: "Show all requests with the given trace id or connection trace id",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		rows, err := db.GetByTraceID(dbConn, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to look up trace: %v\n", err)
+			os.Exit(1)
+		}
+		defer rows.Close()
+
+		err = displayResults(rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache [clear]",
+	Short: "Manage cached query results (clear)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if args[0] != "clear" {
+			return fmt.Errorf("Unknown cache command. Use 'clear'")
+		}
+		removed, err := cache.Clear()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d cached result(s) from %s\n", removed, cache.Dir())
+		return nil
+	},
+}
+
+var validateCmd = &cobra.Command{
+	Use:          "validate [log file...]",
+	Short:        "Check that log files parse as the given log type without importing them",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		validateType, err := db.ParseLogType(logType)
+		if err != nil {
+			return err
+		}
+		dbConn, err := db.Connect(session.InMemoryDBPath)
+		if err != nil {
+			return fmt.Errorf("Failed to connect to db: %w", err)
+		}
+		defer dbConn.Close()
+
+		var malformed int64
+		for _, path := range args {
+			validation, err := db.ValidateLogFile(dbConn, path, validateType)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d line(s), %d parsed, %d malformed\n", path, validation.Lines, validation.Parsed, validation.Malformed)
+			for _, lineError := range validation.Errors {
+				fmt.Printf("  line %d: %s\n", lineError.Line, lineError.Message)
+			}
+			malformed += validation.Malformed
+		}
+		if malformed > 0 {
+			return fmt.Errorf("Found %d malformed line(s), the files don't look like %s logs", malformed, validateType)
+		}
+		return nil
+	},
+}
+
+var benchCmd = &cobra.Command{
+	Use:          "bench",
+	Short:        "Measure how fast this machine parses and imports ALB logs",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger.Infof("Generating %s synthetic log line(s)...\n", formatCount(int64(benchLines)))
+		benchmark, err := db.RunBenchmark(benchLines)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Lines:  %s (%s)\n", formatCount(benchmark.Lines), formatBytes(benchmark.Bytes))
+		for _, phase := range []struct {
+			name     string
+			duration time.Duration
+		}{{"Parse", benchmark.Parse}, {"Import", benchmark.Import}} {
+			seconds := phase.duration.Seconds()
+			fmt.Printf("%-7s %s, %s lines/s, %s/s\n", phase.name+":", phase.duration.Round(time.Millisecond),
+				formatCount(int64(float64(benchmark.Lines)/seconds)), formatBytes(int64(float64(benchmark.Bytes)/seconds)))
+		}
+		return nil
+	},
+}
+
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Show the columns and types of the active session's log table",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, dbConn, err := openSessionDB()
+		if err != nil {
+			exitWithError(err)
+		}
+		defer dbConn.Close()
+
+		rows, err := db.DescribeTable(dbConn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to describe table: %v\n", err)
+			os.Exit(1)
+		}
+		defer rows.Close()
+
+		err = displayResults(rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var fieldsCmd = &cobra.Command{
+	Use:   "fields [list]",
+	Short: "Manage log fields available for queries (list)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		action := args[0]
+		switch action {
+		case "list":
+			fieldsType, err := db.ParseLogType(logType)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			columns := []string{"name", "type", "description", "nullable"}
+			var records [][]interface{}
+			for _, field := range db.Fields(fieldsType) {
+				records = append(records, []interface{}{field.Name, field.Type, field.Description, field.Nullable})
+			}
+			if err := renderResults(columns, records); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to display results: %v\n", err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown fields command. Use 'list'")
+		}
+	},
+}
+
+// Values from the config file only apply to flags that were not given on the command line and
+// are set without marking the flag as changed, so session defaults still take precedence
+func applyConfig(cmd *cobra.Command) (map[string]bool, error) {
+	path := configPath
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	values, err := config.Load(path)
+	if err != nil {
+		if configPath == "" && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to load config file: %v", err)
+	}
+
+	applied := make(map[string]bool)
+	for key, value := range values {
+		// The format key sets the output format, import's --format is the input format
+		if key == "format" && cmd.Name() == "import" {
+			continue
+		}
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return nil, fmt.Errorf("Invalid value for '%s' in config file %s: %v", key, path, err)
+		}
+		applied[key] = true
+	}
+	return applied, nil
+}
+
+func configureThreads(cmd *cobra.Command, fromConfig bool) error {
+	if cmd.Flags().Changed("threads") {
+		return db.SetThreads(threads)
+	}
+
+	env := os.Getenv("LOGWARTS_THREADS")
+	if env == "" {
+		if fromConfig {
+			return db.SetThreads(threads)
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(env)
+	if err != nil {
+		return fmt.Errorf("LOGWARTS_THREADS must be a positive integer, got '%s'", env)
+	}
+	return db.SetThreads(n)
+}
+
+func parseTimeRange(since, until string) (s3.TimeRange, error) {
+	var timeRange s3.TimeRange
+	var err error
+	if since != "" {
+		timeRange.Since, err = timeutil.ParseTimeFlexible(since)
+		if err != nil {
+			return timeRange, fmt.Errorf("Invalid --since: %v", err)
+		}
+	}
+	if until != "" {
+		timeRange.Until, err = timeutil.ParseTimeFlexible(until)
+		if err != nil {
+			return timeRange, fmt.Errorf("Invalid --until: %v", err)
+		}
+	}
+	return timeRange, nil
+}
+
+// printTruncation notes on stderr that --limit cut off rows, so that the note doesn't end up in exported files
+func printTruncation(limit int, total int64) {
+	if limit <= 0 || total <= int64(limit) || logger.Quiet() {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Showing %s of %s rows (use --limit 0 for all)\n", formatCount(int64(limit)), formatCount(total))
+}
+
+// formatCount adds thousands separators, e.g. 4213 becomes 4,213
+func formatCount(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+type progress interface {
+	Set(current int) error
+}
+
+// jsonProgress writes one JSON object per update to stderr for programs driving logwarts
+type jsonProgress struct {
+	Phase   string `json:"phase"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+}
+
+func (p *jsonProgress) Set(current int) error {
+	p.Current = current
+	event, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stderr, "%s\n", event)
+	return err
+}
+
+// syncProgress drives a progress from many workers: updates are serialized, never move backwards
+// and are capped at the total, so a bar neither flickers nor exceeds 100% when workers report out of order
+type syncProgress struct {
+	mu      sync.Mutex
+	bar     progress
+	current int
+	total   int
+}
+
+func (p *syncProgress) Set(current int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current = min(current, p.total)
+	if current <= p.current {
+		return nil
+	}
+	p.current = current
+	return p.bar.Set(current)
+}
+
+func newProgress(mode string, phase string, max int, description string) *syncProgress {
+	var bar progress
+	if mode == "json" {
+		bar = &jsonProgress{Phase: phase, Total: max}
+	} else if logger.Quiet() {
+		bar = progressbar.DefaultSilent(int64(max), description)
+	} else {
+		bar = progressbar.Default(int64(max), description)
+	}
+	return &syncProgress{bar: bar, total: max}
+}
+
+// finishInterruptedImport makes sure everything imported so far is in the database file before exiting
+func finishInterruptedImport(dbConn *sql.DB) error {
+	if err := db.Checkpoint(dbConn); err != nil {
+		return err
+	}
+	logger.Errorf("Import interrupted, the files imported so far were committed. Run the same import again to continue\n")
+	return fmt.Errorf("Import interrupted")
+}
+
+// recordTransfer stores the bytes of the requests imported since before was summed in result
+func recordTransfer(dbConn *sql.DB, sess *session.Session, result *db.ImportResult, before db.Transfer) error {
+	after, err := db.SumTransferredBytes(dbConn, sess)
+	if err != nil {
+		return err
+	}
+	result.Transferred = after.Sub(before)
+	return nil
+}
+
+func printDownloadSummary(result *s3.DownloadResult, downloadDir string) {
+	if len(result.Skipped) > 0 {
+		logger.Infof("Skipped %d already imported log file(s)\n", len(result.Skipped))
+	}
+	for _, failed := range result.Failed {
+		logger.Errorf("Failed to download log file '%s': %v\n", failed.Key, failed.Err)
+	}
+	if result.Interrupted {
+		logger.Infof("Download interrupted after %d of %d log file(s)\n", len(result.Downloaded), result.Listed-len(result.Skipped))
+	}
+	logger.Infof("Downloaded %d log file(s), %s to '%s'\n", len(result.Downloaded), formatBytes(result.Bytes()), downloadDir)
+}
+
+func printImportSummary(result *db.ImportResult) {
+	fmt.Printf("Imported %d/%d file(s), %d row(s)\n", result.Succeeded(), len(result.Files), result.Rows())
+	if result.DownloadedBytes > 0 {
+		fmt.Printf("Downloaded %s from S3\n", formatBytes(result.DownloadedBytes))
+	}
+	if result.Rows() > 0 {
+		fmt.Printf("Imported requests received %s and sent %s\n", formatBytes(result.Transferred.Received), formatBytes(result.Transferred.Sent))
+	}
+	if len(result.Skipped) > 0 {
+		logger.Infof("Skipped %d file(s) without a .log or .log.gz extension\n", len(result.Skipped))
+	}
+	if len(result.AlreadyImported) > 0 {
+		logger.Infof("Skipped %d file(s) that were already imported, use --force to import them again\n", len(result.AlreadyImported))
+	}
+	for _, file := range result.Failed() {
+		logger.Errorf("Failed to import file '%s': %v\n", file.Path, file.Err)
+	}
+}
+
+func enrichGeoIP(dbConn *sql.DB, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var readers []*geoip.Reader
+	for _, path := range paths {
+		reader, err := geoip.Open(path)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, reader)
+	}
+	resolved, err := db.EnrichGeoIP(dbConn, readers)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Resolved %d client IP(s) with GeoIP\n", resolved)
+	return nil
+}
+
+func importError(result *db.ImportResult, opts ImportOptions) error {
+	failed := result.Failed()
+	if len(failed) > 0 && !opts.IgnoreErrors {
+		paths := make([]string, len(failed))
+		for i, file := range failed {
+			paths[i] = file.Path
+		}
+		return fmt.Errorf("Failed to import %d file(s): %s", len(failed), strings.Join(paths, ", "))
+	}
+
+	// An empty import usually means a wrong prefix, glob or file list
+	if opts.FailOnEmpty && result.Rows() == 0 {
+		if len(result.Files) == 0 {
+			return fmt.Errorf("No files were imported and --fail-on-empty is set")
+		}
+		return fmt.Errorf("No rows were imported from %d file(s) and --fail-on-empty is set", len(result.Files))
+	}
+	return nil
+}
+
+// Session defaults only apply to flags that were not given on the command line
+func applySessionDefaults(cmd *cobra.Command, sess *session.Session) {
+	flags := cmd.Flags()
+	defaults := sess.Defaults
+	if defaults.Format != "" && flags.Lookup("format") != nil && !flags.Changed("format") {
+		outputFormat = defaults.Format
+	}
+	if cmd.Name() != "stats" {
+		return
+	}
+	if defaults.Filter != "" && !flags.Changed("filter") {
+		statsRequestFilter = []string{defaults.Filter}
+	}
+	if defaults.Exclude != "" && !flags.Changed("exclude") {
+		statsExclude = []string{defaults.Exclude}
+	}
+	if defaults.Interval != "" && !flags.Changed("interval") {
+		statsInterval = defaults.Interval
+	}
+}
+
+func validateSessionDefault(key string, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch key {
+	case "filter", "exclude":
+		_, err := sanitizeRegex(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid regex pattern: %v", value, err)
+		}
+	case "format":
+		if !slices.Contains(output.Formats, value) {
+			return fmt.Errorf("Unknown output format '%s'. Use one of: %s", value, strings.Join(output.Formats, ", "))
+		}
+	case "interval":
+		if !slices.Contains(db.StatsIntervals, value) {
+			return fmt.Errorf("Invalid interval '%s', use one of: %s", value, strings.Join(db.StatsIntervals, ", "))
+		}
+	}
+	return nil
+}
+
+// Only plain reads are cached, statements that change the session's data must always run
+func isReadOnlyQuery(sqlQuery string) bool {
+	fields := strings.Fields(sqlQuery)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "FROM":
+		return true
+	}
+	return false
+}
+
+// queryCacheKey identifies a query result by the session, the rewritten SQL and the state of the session's table
+func queryCacheKey(dbConn *sql.DB, sess *session.Session, sqlQuery string) (string, error) {
+	state, err := db.TableState(dbConn, sess)
+	if err != nil {
+		return "", err
+	}
+	return cache.Key(sess.DBPath, sqlQuery, state), nil
+}
+
+func openSessionDB() (*session.Session, *sql.DB, error) {
+	sess, err := session.GetActiveSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get active session: %w", err)
+	}
+	dbConn, err := connectForQuery(sess)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to connect to db: %w", err)
+	}
+
+	if sess.InMemory() {
+		if err := importInMemory(dbConn); err != nil {
+			dbConn.Close()
+			return nil, nil, fmt.Errorf("Failed to import logs into memory: %v", err)
+		}
+	}
+	return sess, dbConn, nil
+}
+
+func connectForQuery(sess *session.Session) (*sql.DB, error) {
+	// In-memory databases are populated right before querying and cannot be opened read-only
+	if sess.InMemory() {
+		return db.Connect(sess.DBPath)
+	}
+	return db.ConnectReadOnly(sess.DBPath)
+}
+
+func readFilenames(r io.Reader) ([]string, error) {
+	var files []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		filename := strings.TrimSpace(s.Text())
+		if filename != "" {
+			files = append(files, filename)
+		}
+	}
+	return files, s.Err()
+}
+
+// importStdinContent spools the logs piped into stdin to a temporary file, so they can be loaded with a single COPY
+func importStdinContent(opts ImportOptions, importType db.LogType, importFile func(*sql.DB, string) (int64, error)) error {
+	sess, err := session.GetActiveSession()
+	if err != nil {
+		return fmt.Errorf("Failed to get active session: %w", err)
+	}
+	dbConn, err := db.Connect(sess.DBPath)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to db: %w", err)
+	}
+	defer dbConn.Close()
+
+	if err := db.Migrate(dbConn, sess); err != nil {
+		return err
+	}
+	if err := db.CheckLogType(dbConn, importType); err != nil {
+		return err
+	}
+	before, err := db.SumTransferredBytes(dbConn, sess)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp("", "logwarts-stdin-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	written, err := io.Copy(file, opts.Stdin)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading from stdin: %v", err)
+	}
+	logger.Debugf("Spooled %d byte(s) from stdin to '%s'", written, file.Name())
+
+	result := &db.ImportResult{}
+	rows, err := importFile(dbConn, file.Name())
+	result.Add("stdin", rows, err)
+	if err := recordTransfer(dbConn, sess, result, before); err != nil {
+		return err
+	}
+	printImportSummary(result)
+	if err := enrichGeoIP(dbConn, opts.GeoIP); err != nil {
+		return err
+	}
+	return importError(result, opts)
+}
+
+func importInMemory(dbConn *sql.DB) error {
+	files, err := readFilenames(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("Error reading from stdin: %v", err)
+	}
+
+	err = db.InitializeLogTable(dbConn, db.LogTypeALB)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range files {
+		_, err := db.ImportLogFile(dbConn, filePath)
+		if err != nil {
+			return fmt.Errorf("Failed to import file '%s': %v", filePath, err)
+		}
+	}
+	return nil
+}
+
+func sanitizeRegex(pattern string) (string, error) {
+	_, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return pattern, nil
+}
+
+func printCSVHeader(dbConn *sql.DB, sqlQuery string) error {
+	columnTypes, err := db.QueryColumns(dbConn, sqlQuery)
+	if err != nil {
+		return fmt.Errorf("Failed to prepare query: %v", err)
+	}
+	names := make([]string, len(columnTypes))
+	types := make([]string, len(columnTypes))
+	for i, columnType := range columnTypes {
+		names[i] = columnType.Name()
+		types[i] = columnType.DatabaseTypeName()
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write(names)
+	if withTypes {
+		w.Write(types)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func sanitizeRegexes(patterns []string) ([]string, error) {
+	var sanitized []string
+	for _, pattern := range patterns {
+		p, err := sanitizeRegex(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sanitized = append(sanitized, p)
+	}
+	return sanitized, nil
+}
+
+func displayResults(rows *sql.Rows) error {
+	if bufferResults() {
+		columns, records, err := scanResults(rows)
+		if err != nil {
+			return err
+		}
+		return renderResults(columns, records)
+	}
+	return streamResults(rows, nil)
+}
+
+// bufferResults reports whether every row has to be read before the first one is written:
+// tables fit their columns to all values and show totals below them, and --sort orders all rows.
+// All other formats are written row by row as they are read
+func bufferResults() bool {
+	return outputFormat == "table" || sortBy != ""
+}
+
+// streamResults writes the rows as they are read without holding them in memory,
+// collect is called with every complete row before --columns picks from it
+func streamResults(rows *sql.Rows, collect func(record []interface{})) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("Failed to get columns: %v", err)
+	}
+	indexes, err := columnIndexes(columns, selectedColumns)
+	if err != nil {
+		return err
+	}
+	projected := make([]string, len(indexes))
+	for i, index := range indexes {
+		projected[i] = columns[index]
+	}
+
+	w, appended, closeOutput, err := openOutput()
+	if err != nil {
+		return err
+	}
+	writer, err := newResultWriter(w, appended)
+	if err != nil {
+		closeOutput()
+		return err
+	}
+
+	err = func() error {
+		if err := writer.WriteHeader(projected); err != nil {
+			return err
+		}
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(valuePtrs...); err != nil {
+				return fmt.Errorf("Failed to scan row: %v", err)
+			}
+			if collect != nil {
+				record := make([]interface{}, len(columns))
+				copy(record, values)
+				collect(record)
+			}
+			if err := writer.WriteRow(projectRecord(values, indexes)); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("Error during rows iteration: %v", err)
+		}
+		return writer.Flush()
+	}()
+	if err != nil {
+		closeOutput()
+		return err
+	}
+	return closeOutput()
+}
+
+func scanResults(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get columns: %v", err)
+	}
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	var records [][]interface{}
+	for rows.Next() {
+		err := rows.Scan(valuePtrs...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to scan row: %v", err)
+		}
+
+		record := make([]interface{}, len(columns))
+		copy(record, values)
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return columns, records, nil
+}
+
+func renderResults(columns []string, records [][]interface{}) error {
+	var err error
+	if len(selectedColumns) > 0 {
+		columns, records, err = projectColumns(columns, records, selectedColumns)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sortBy != "" {
+		if err := sortRecords(columns, records, sortBy); err != nil {
+			return err
+		}
+	}
+
+	w, appended, closeOutput, err := openOutput()
+	if err != nil {
+		return err
+	}
+	writer, err := newResultWriter(w, appended)
+	if err != nil {
+		closeOutput()
+		return err
+	}
+	err = writeResults(writer, columns, records)
+	if err != nil {
+		closeOutput()
+		return err
+	}
+
+	return closeOutput()
+}
+
+func newResultWriter(w io.Writer, appended bool) (output.Writer, error) {
+	// A file that is appended to already starts with a header
+	header := !noHeader && !appended
+	return output.NewWriter(outputFormat, w, output.Options{Header: header, Null: nullPlaceholder(), FixedWidths: noOptimize, JSONLines: appendOutput})
+}
+
+// columnIndexes returns the indexes of the selected columns, or of all columns if none are selected
+func columnIndexes(columns []string, selected []string) ([]int, error) {
+	if len(selected) == 0 {
+		indexes := make([]int, len(columns))
+		for i := range columns {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+
+	indexes := make([]int, len(selected))
+	for i, name := range selected {
+		indexes[i] = -1
+		for j, column := range columns {
+			if column == strings.TrimSpace(name) {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] < 0 {
+			return nil, fmt.Errorf("Unknown column '%s'. Valid columns are: %s", name, strings.Join(columns, ", "))
+		}
+	}
+	return indexes, nil
+}
+
+func projectRecord(record []interface{}, indexes []int) []interface{} {
+	projected := make([]interface{}, len(indexes))
+	for i, index := range indexes {
+		projected[i] = record[index]
+	}
+	return projected
+}
+
+func projectColumns(columns []string, records [][]interface{}, selected []string) ([]string, [][]interface{}, error) {
+	indexes, err := columnIndexes(columns, selected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	projectedColumns := make([]string, len(indexes))
+	for i, index := range indexes {
+		projectedColumns[i] = columns[index]
+	}
+	projectedRecords := make([][]interface{}, len(records))
+	for i, record := range records {
+		projectedRecords[i] = projectRecord(record, indexes)
+	}
+	return projectedColumns, projectedRecords, nil
+}
+
+func writeResults(writer output.Writer, columns []string, records [][]interface{}) error {
+	if err := writer.WriteHeader(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := writer.WriteRow(record); err != nil {
+			return err
+		}
+	}
+	if showTotals {
+		if footerWriter, ok := writer.(output.FooterWriter); ok {
+			if err := footerWriter.WriteFooter(totalsRow(records, len(columns))); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+func nullPlaceholder() string {
+	if nullStringSet {
+		return nullString
+	}
+	if outputFormat == "csv" {
+		return ""
+	}
+	return "NULL"
+}
+
+func totalsRow(records [][]interface{}, columnCount int) []string {
+	footer := make([]string, columnCount)
+	for i := 0; i < columnCount; i++ {
+		var sum float64
+		numeric, integral := false, true
+		for _, record := range records {
+			if record[i] == nil {
+				continue
+			}
+			value, isInt, ok := toNumber(record[i])
+			if !ok {
+				numeric = false
+				break
+			}
+			numeric = true
+			integral = integral && isInt
+			sum += value
+		}
+		if !numeric {
+			continue
+		}
+		if integral {
+			footer[i] = fmt.Sprintf("%.0f", sum)
+		} else {
+			footer[i] = fmt.Sprintf("%v", sum)
+		}
+	}
+	if columnCount > 0 && footer[0] == "" {
+		footer[0] = "total"
+	}
+	return footer
+}
+
+// sortRecords sorts by "column" or "column:desc". A column whose values are all numbers is compared
+// numerically, otherwise by its formatted values. NULLs always come last
+func sortRecords(columns []string, records [][]interface{}, spec string) error {
+	name, direction, _ := strings.Cut(spec, ":")
+	descending := false
+	switch strings.ToLower(direction) {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return fmt.Errorf("Invalid sort direction '%s'. Use 'asc' or 'desc'", direction)
+	}
+
+	index := slices.Index(columns, strings.TrimSpace(name))
+	if index == -1 {
+		return fmt.Errorf("Unknown sort column '%s', available columns: %s", name, strings.Join(columns, ", "))
+	}
+
+	numeric := true
+	for _, record := range records {
+		if record[index] == nil {
+			continue
+		}
+		if _, _, ok := toNumber(record[index]); !ok {
+			numeric = false
+			break
+		}
+	}
+
+	compare := func(a, b interface{}) int {
+		if numeric {
+			x, _, _ := toNumber(a)
+			y, _, _ := toNumber(b)
+			return cmp.Compare(x, y)
+		}
+		if x, ok := a.(time.Time); ok {
+			if y, ok := b.(time.Time); ok {
+				return x.Compare(y)
+			}
+		}
+		return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+	}
+
+	slices.SortStableFunc(records, func(a, b []interface{}) int {
+		switch {
+		case a[index] == nil && b[index] == nil:
+			return 0
+		case a[index] == nil:
+			return 1
+		case b[index] == nil:
+			return -1
+		}
+		if descending {
+			return compare(b[index], a[index])
+		}
+		return compare(a[index], b[index])
+	})
+	return nil
+}
+
+func toNumber(val interface{}) (float64, bool, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true, true
+	case int8:
+		return float64(v), true, true
+	case int16:
+		return float64(v), true, true
+	case int32:
+		return float64(v), true, true
+	case int64:
+		return float64(v), true, true
+	case uint8:
+		return float64(v), true, true
+	case uint16:
+		return float64(v), true, true
+	case uint32:
+		return float64(v), true, true
+	case uint64:
+		return float64(v), true, true
+	case float32:
+		return float64(v), false, true
+	case float64:
+		return v, false, true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true, true
+	}
+	return 0, false, false
+}
+
+func usePager() bool {
+	switch pagerMode {
+	case "always":
+		return true
+	case "auto":
+		return output.StdoutIsTerminal()
+	}
+	return false
+}
+
+// openOutput also reports whether the output is appended to a file that already has content
+func openOutput() (io.Writer, bool, func() error, error) {
+	compress := gzipOutput || strings.HasSuffix(outputPath, ".gz")
+	if outputPath == "" {
+		if gzipOutput {
+			return nil, false, nil, fmt.Errorf("--gzip requires --output")
+		}
+		if appendOutput {
+			return nil, false, nil, fmt.Errorf("--append requires --output")
+		}
+		if pagerMode != "auto" && pagerMode != "always" && pagerMode != "never" {
+			return nil, false, nil, fmt.Errorf("Invalid pager mode '%s'. Use 'auto', 'always', or 'never'", pagerMode)
+		}
+		if usePager() {
+			w, closePager := output.StartPager()
+			return w, false, closePager, nil
+		}
+		return os.Stdout, false, func() error { return nil }, nil
+	}
+
+	var file *os.File
+	var err error
+	appended := false
+	if appendOutput {
+		if outputFormat == "table" {
+			return nil, false, nil, fmt.Errorf("--append cannot be used with --format table, use csv, json, markdown or html")
+		}
+		file, err = os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, false, nil, fmt.Errorf("Failed to open output file '%s': %v", outputPath, err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, false, nil, fmt.Errorf("Failed to stat output file '%s': %v", outputPath, err)
+		}
+		appended = info.Size() > 0
+	} else {
+		file, err = os.Create(outputPath)
+		if err != nil {
+			return nil, false, nil, fmt.Errorf("Failed to create output file '%s': %v", outputPath, err)
+		}
+	}
+	if !compress {
+		return file, appended, file.Close, nil
+	}
+
+	// Appended gzip output becomes a multi-member gzip file, which gunzip and zcat read as one stream
+	gz := gzip.NewWriter(file)
+	return gz, appended, func() error {
+		if err := gz.Close(); err != nil {
+			file.Close()
+			return fmt.Errorf("Failed to finish gzip output: %v", err)
+		}
+		return file.Close()
+	}, nil
 }
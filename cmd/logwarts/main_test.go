@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frederikmartin/logwarts/internal/db"
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+func TestAppendJSONTwiceStaysDecodable(t *testing.T) {
+	outputPath = filepath.Join(t.TempDir(), "results.json")
+	outputFormat = "json"
+	appendOutput = true
+	t.Cleanup(func() {
+		outputPath = ""
+		outputFormat = "table"
+		appendOutput = false
+	})
+
+	columns := []string{"client", "requests"}
+	for _, records := range [][][]interface{}{
+		{{"192.0.2.1", int64(3)}, {"192.0.2.2", int64(1)}},
+		{{"192.0.2.3", int64(7)}},
+	} {
+		if err := renderResults(columns, records); err != nil {
+			t.Fatalf("renderResults: %v", err)
+		}
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var clients []string
+	decoder := json.NewDecoder(file)
+	for {
+		var row map[string]interface{}
+		err := decoder.Decode(&row)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Appended output is not valid JSON: %v", err)
+		}
+		clients = append(clients, row["client"].(string))
+	}
+	if len(clients) != 3 || clients[0] != "192.0.2.1" || clients[2] != "192.0.2.3" {
+		t.Errorf("Decoded clients %v, want the 3 rows of both runs in order", clients)
+	}
+}
+
+func TestStreamResultsWritesEveryRowWithoutBuffering(t *testing.T) {
+	dbConn, err := db.Connect(session.InMemoryDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+
+	outputPath = filepath.Join(t.TempDir(), "results.csv")
+	outputFormat = "csv"
+	selectedColumns = []string{"label", "i"}
+	t.Cleanup(func() {
+		outputPath = ""
+		outputFormat = "table"
+		selectedColumns = nil
+	})
+	if bufferResults() {
+		t.Fatal("CSV output without --sort is buffered")
+	}
+
+	rows, err := dbConn.Query(`SELECT i, 'row ' || i AS label, NULL AS empty FROM range(5000) t(i) ORDER BY i`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	collected := 0
+	if err := streamResults(rows, func(record []interface{}) {
+		if len(record) != 3 {
+			t.Fatalf("collect got %d column(s), want all 3 before --columns picks from them", len(record))
+		}
+		collected++
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5001 || collected != 5000 {
+		t.Fatalf("Wrote %d CSV line(s) and collected %d row(s), want a header and 5000 rows", len(records), collected)
+	}
+	if records[0][0] != "label" || records[0][1] != "i" || records[4000][0] != "row 3999" || records[4000][1] != "3999" {
+		t.Errorf("Unexpected header %v or row %v", records[0], records[4000])
+	}
+}
+
+func TestBufferResultsOnlyForTablesAndSorting(t *testing.T) {
+	t.Cleanup(func() {
+		outputFormat = "table"
+		sortBy = ""
+	})
+	for _, tc := range []struct {
+		format, sort string
+		buffer       bool
+	}{
+		{"table", "", true},
+		{"csv", "", false},
+		{"json", "", false},
+		{"markdown", "", false},
+		{"html", "", false},
+		{"csv", "requests:desc", true},
+	} {
+		outputFormat, sortBy = tc.format, tc.sort
+		if bufferResults() != tc.buffer {
+			t.Errorf("bufferResults() with --format %s --sort %q = %t, want %t", tc.format, tc.sort, !tc.buffer, tc.buffer)
+		}
+	}
+}
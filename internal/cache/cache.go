@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result is a cached query result as scanned from the database
+type Result struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+func init() {
+	// Types DuckDB scans into interface values besides gob's basic types
+	gob.Register(time.Time{})
+	gob.Register(&big.Int{})
+}
+
+const fileExtension = ".gob"
+
+// Dir is the directory cached results are stored in, it is shared by all sessions
+func Dir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "logwarts")
+	}
+	return filepath.Join(os.TempDir(), "logwarts_cache")
+}
+
+// Key hashes everything a result depends on into a file name
+func Key(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load returns the result cached under key, unreadable entries are treated as missing
+func Load(key string) (*Result, bool) {
+	file, err := os.Open(filepath.Join(Dir(), key+fileExtension))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	var result Result
+	if err := gob.NewDecoder(file).Decode(&result); err != nil {
+		return nil, false
+	}
+	return &result, true
+}
+
+// Store writes the result to a temporary file first so that concurrent readers never see a partial entry
+func Store(key string, result *Result) error {
+	dir := Dir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("Failed to create cache directory: %v", err)
+	}
+	file, err := os.CreateTemp(dir, key+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("Failed to create cache file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(result); err != nil {
+		file.Close()
+		return fmt.Errorf("Failed to encode result: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Failed to write cache file: %v", err)
+	}
+	if err := os.Rename(file.Name(), filepath.Join(dir, key+fileExtension)); err != nil {
+		return fmt.Errorf("Failed to write cache file: %v", err)
+	}
+	return nil
+}
+
+// Clear removes all cached results and returns how many there were
+func Clear() (int, error) {
+	entries, err := os.ReadDir(Dir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read cache directory: %v", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), fileExtension) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(Dir(), entry.Name())); err != nil {
+			return removed, fmt.Errorf("Failed to remove cache file: %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
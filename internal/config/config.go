@@ -0,0 +1,96 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Keys are the flags that can be given a default value in the config file
+var Keys = []string{"bucket", "prefix", "download-dir", "region", "format", "threads"}
+
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "logwarts", "config.yaml")
+}
+
+// Load reads a flat YAML file of "key: value" lines. Keys may use '-' or '_',
+// values may be quoted and '#' starts a comment
+func Load(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	s := bufio.NewScanner(file)
+	for lineNumber := 1; s.Scan(); lineNumber++ {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("%s:%d: nested values are not supported", path, lineNumber)
+		}
+
+		key, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected 'key: value'", path, lineNumber)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if !isKey(key) {
+			return nil, fmt.Errorf("%s:%d: unknown key '%s', use one of: %s", path, lineNumber, key, strings.Join(Keys, ", "))
+		}
+		value, err = parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		values[key] = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %v", err)
+	}
+	return values, nil
+}
+
+func parseValue(value string) (string, error) {
+	if strings.HasPrefix(value, `"`) {
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	}
+	if strings.HasPrefix(value, "'") {
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.ReplaceAll(value[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(value, " #"); i != -1 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+func isKey(key string) bool {
+	for _, k := range Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
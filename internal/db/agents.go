@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// GetUserAgents returns up to limit user agents, 0 returns all, and the number of user agents without the limit
+func GetUserAgents(db *sql.DB, filter StatsFilter, limit int, normalize bool) (*sql.Rows, int64, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to get active session for user agents: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Strips product versions such as "/120.0.6099.109" so that releases of the same client are grouped
+	agent := "user_agent"
+	if normalize {
+		agent = `TRIM(REGEXP_REPLACE(user_agent, '/[0-9][^ ;)]*', '', 'g'))`
+	}
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+	SELECT
+            %s AS user_agent,
+            COUNT(*) AS requests,
+            SUM(received_bytes) AS received_bytes,
+            SUM(sent_bytes) AS sent_bytes
+        FROM
+            %s
+	WHERE %s
+	GROUP BY
+            1
+        ORDER BY
+            requests DESC`, agent, tableName, where)
+
+	return queryWithLimit(db, query, args, limit)
+}
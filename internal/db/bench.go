@@ -0,0 +1,138 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+type Benchmark struct {
+	Lines int64
+	Bytes int64
+	// Parse is the time DuckDB needs to read and convert all columns without storing them
+	Parse time.Duration
+	// Import is the time of the COPY into a log table, the same statement import uses
+	Import time.Duration
+}
+
+// benchSeed keeps the generated lines the same across runs so that results are comparable
+const benchSeed = 42
+
+// RunBenchmark generates lines synthetic ALB log lines in a temporary file and measures parsing
+// and importing them into an in-memory database, independent of any session
+func RunBenchmark(lines int) (*Benchmark, error) {
+	if lines < 1 {
+		return nil, fmt.Errorf("Line count must be a positive integer, got %d", lines)
+	}
+
+	dir, err := os.MkdirTemp("", "logwarts-bench-*")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bench.log")
+	size, err := writeBenchLog(path, lines)
+	if err != nil {
+		return nil, err
+	}
+	benchmark := &Benchmark{Lines: int64(lines), Bytes: size}
+
+	db, err := Connect(session.InMemoryDBPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	schema := Schema(LogTypeALB)
+	columns := make([]string, len(schema))
+	definitions := make([]string, len(schema))
+	for i, column := range schema {
+		columns[i] = fmt.Sprintf("'%s': '%s'", column.Name, column.Type)
+		definitions[i] = fmt.Sprintf("%s %s", column.Name, column.Type)
+	}
+
+	// Aggregating every column keeps DuckDB from skipping the conversion of columns that aren't used
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), MAX(COLUMNS(*)) FROM read_csv('%s', DELIM = ' ', HEADER = FALSE, QUOTE = '"', ESCAPE = '"', NULLSTR = '-', NULL_PADDING = TRUE,
+			COLUMNS = {%s});
+	`, strings.ReplaceAll(path, "'", "''"), strings.Join(columns, ", "))
+	debugSQL(query)
+	start := time.Now()
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse benchmark log: %v", err)
+	}
+	for rows.Next() {
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse benchmark log: %v", err)
+	}
+	benchmark.Parse = time.Since(start)
+
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE alb_logs_bench (%s);`, strings.Join(definitions, ", ")))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create benchmark table: %v", err)
+	}
+	start = time.Now()
+	imported, err := copyLogFile(db, "alb_logs_bench", path, DefaultCopyOptions)
+	if err != nil {
+		return nil, err
+	}
+	benchmark.Import = time.Since(start)
+	if imported != benchmark.Lines {
+		return nil, fmt.Errorf("Imported %d of %d benchmark line(s)", imported, lines)
+	}
+	return benchmark, nil
+}
+
+var (
+	benchMethods    = []string{"GET", "GET", "GET", "POST", "PUT", "DELETE"}
+	benchPaths      = []string{"/", "/health", "/api/v1/users", "/api/v1/orders", "/api/v1/login", "/static/app.js", "/images/logo.png"}
+	benchStatuses   = []int{200, 200, 200, 200, 201, 204, 301, 304, 400, 404, 500, 502}
+	benchUserAgents = []string{"Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", "curl/8.5.0", "Googlebot/2.1", "okhttp/4.12.0"}
+)
+
+func writeBenchLog(path string, lines int) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create benchmark log: %v", err)
+	}
+	defer file.Close()
+
+	random := rand.New(rand.NewSource(benchSeed))
+	w := bufio.NewWriter(file)
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	var size int64
+	for i := 0; i < lines; i++ {
+		t := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		status := benchStatuses[random.Intn(len(benchStatuses))]
+		target := fmt.Sprintf("10.0.%d.%d:8080", random.Intn(4), random.Intn(256))
+		processing := random.Float64() * 0.5
+		n, err := fmt.Fprintf(w, `https %s app/bench-alb/50dc6c495c0c9188 192.0.2.%d:%d %s 0.000 %.3f 0.000 %d %d %d %d "%s https://bench.example.com:443%s HTTP/1.1" "%s" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/bench/73e2d6bc24d8a067 "Root=1-%08x-%024x" "bench.example.com" "arn:aws:acm:us-east-1:123456789012:certificate/12345678-1234-1234-1234-123456789012" 0 %s "forward" "-" "-" "%s" "%d" "-" "-" TID_%016x "-" "-" "-"`+"\n",
+			t.Format("2006-01-02T15:04:05.000000Z"),
+			random.Intn(256), 1024+random.Intn(60000), target,
+			processing, status, status, 100+random.Intn(2000), 200+random.Intn(50000),
+			benchMethods[random.Intn(len(benchMethods))], benchPaths[random.Intn(len(benchPaths))],
+			benchUserAgents[random.Intn(len(benchUserAgents))],
+			t.Unix(), random.Int63(),
+			t.Format("2006-01-02T15:04:05.000000Z"),
+			target, status, random.Int63())
+		if err != nil {
+			return 0, fmt.Errorf("Failed to write benchmark log: %v", err)
+		}
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("Failed to write benchmark log: %v", err)
+	}
+	return size, nil
+}
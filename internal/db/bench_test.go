@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	// The benchmark log goes to the temporary directory, whose path may need quoting in SQL
+	dir := filepath.Join(t.TempDir(), "it's tmp")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", dir)
+
+	benchmark, err := RunBenchmark(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if benchmark.Lines != 100 || benchmark.Bytes == 0 {
+		t.Errorf("RunBenchmark(100) = %+v, want 100 lines and their size", benchmark)
+	}
+	if _, err := RunBenchmark(0); err == nil {
+		t.Error("RunBenchmark(0) succeeded")
+	}
+}
+
+func TestBenchLogMatchesSchema(t *testing.T) {
+	_, dbConn := newTestSession(t)
+	path := filepath.Join(t.TempDir(), "bench.log")
+	if _, err := writeBenchLog(path, 50); err != nil {
+		t.Fatal(err)
+	}
+	validation, err := ValidateLogFile(dbConn, path, LogTypeALB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validation.Parsed != 50 || validation.Malformed != 0 {
+		t.Errorf("Generated benchmark log: %+v, want 50 well-formed lines", validation)
+	}
+}
+
+// BenchmarkImportFiles compares --workers settings on a set of generated log files
+func BenchmarkImportFiles(b *testing.B) {
+	sess, dbConn := newTestSession(b)
+	tableName, err := TableName(sess)
+	if err != nil {
+		b.Fatal(err)
+	}
+	const files, lines = 8, 5000
+	dir := b.TempDir()
+	paths := make([]string, files)
+	var size int64
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		n, err := writeBenchLog(paths[i], lines)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size += n
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if _, err := dbConn.Exec(`DELETE FROM ` + tableName); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				result := ImportFiles(context.Background(), dbConn, paths, workers, ImportLogFile, nil)
+				if rows := result.Rows(); rows != files*lines {
+					b.Fatalf("Imported %d row(s), want %d", rows, files*lines)
+				}
+			}
+		})
+	}
+}
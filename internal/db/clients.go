@@ -0,0 +1,39 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// GetClients ranks client IPs by request count, with the number of distinct paths they requested
+// and the share of their requests the load balancer answered with 4xx or 5xx. Like GetUserAgents it also
+// returns the number of client IPs without the limit
+func GetClients(db *sql.DB, filter StatsFilter, limit int) (*sql.Rows, int64, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to get active session for clients: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+	SELECT
+            REGEXP_EXTRACT(client, '^(.*):[0-9]+$', 1) AS client_ip,
+            COUNT(*) AS requests,
+            COUNT(DISTINCT REGEXP_EXTRACT(request, '^\S+ [a-z]+://[^/]+(/[^? ]*)', 1)) AS distinct_paths,
+            ROUND(100.0 * COUNT(*) FILTER (WHERE elb_status_code >= 400) / COUNT(*), 2) AS error_rate
+        FROM
+            %s
+	WHERE %s
+	GROUP BY
+            client_ip
+        ORDER BY
+            requests DESC`, tableName, where)
+
+	return queryWithLimit(db, query, args, limit)
+}
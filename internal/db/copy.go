@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// CopyOptions are the CSV options log files are read with, the defaults match ALB's log format
+type CopyOptions struct {
+	Delimiter string
+	Quote     string
+	Escape    string
+	Null      string
+}
+
+var DefaultCopyOptions = CopyOptions{Delimiter: " ", Quote: `"`, Escape: `"`, Null: "-"}
+
+// ParseCopyOptions validates the options given on the command line, a literal \t stands for a tab
+func ParseCopyOptions(delimiter, quote, escape, null string) (CopyOptions, error) {
+	options := CopyOptions{
+		Delimiter: strings.ReplaceAll(delimiter, `\t`, "\t"),
+		Quote:     strings.ReplaceAll(quote, `\t`, "\t"),
+		Escape:    strings.ReplaceAll(escape, `\t`, "\t"),
+		Null:      null,
+	}
+	for _, option := range []struct{ name, value string }{
+		{"delimiter", options.Delimiter},
+		{"quote", options.Quote},
+		{"escape", options.Escape},
+	} {
+		if utf8.RuneCountInString(option.value) != 1 {
+			return options, fmt.Errorf("The %s must be a single character, got '%s'", option.name, option.value)
+		}
+	}
+	for _, value := range []string{options.Delimiter, options.Quote, options.Escape, options.Null} {
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return options, fmt.Errorf("Copy options must not contain line breaks or NUL characters, got %q", value)
+		}
+	}
+	if options.Delimiter == options.Quote {
+		return options, fmt.Errorf("The delimiter and the quote must differ, got '%s' for both", options.Delimiter)
+	}
+	return options, nil
+}
+
+// clause returns the options for COPY, the values are embedded as escaped string literals
+func (o CopyOptions) clause() string {
+	literal := func(value string) string {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return fmt.Sprintf("DELIMITER %s, QUOTE %s, ESCAPE %s, NULL %s", literal(o.Delimiter), literal(o.Quote), literal(o.Escape), literal(o.Null))
+}
+
+// LogFileImporter returns an ImportLogFile that reads log files with the options
+func LogFileImporter(options CopyOptions) func(*sql.DB, string) (int64, error) {
+	return func(db *sql.DB, logFilePath string) (int64, error) {
+		activeSession, err := session.GetActiveSession()
+		if err != nil {
+			return 0, fmt.Errorf("Failed to get active session for import: %w", err)
+		}
+		tableName, err := TableName(activeSession)
+		if err != nil {
+			return 0, err
+		}
+
+		return copyLogFile(db, tableName, logFilePath, options)
+	}
+}
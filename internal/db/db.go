@@ -1,82 +1,130 @@
 package db
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/frederikmartin/logwarts/internal/logger"
 	"github.com/frederikmartin/logwarts/internal/session"
 	_ "github.com/marcboeker/go-duckdb"
 )
 
+var (
+	threads     = runtime.NumCPU()
+	memoryLimit string
+)
+
+// Session names end up unquoted in DDL, so they are checked here again even though they are sanitized on create
+var sessionNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// TableName returns the name of the session's log table, every table reference must be built with it
+func TableName(sess *session.Session) (string, error) {
+	if !sessionNamePattern.MatchString(sess.Name) {
+		return "", fmt.Errorf("%w '%s': must match %s", ErrInvalidSessionName, sess.Name, sessionNamePattern)
+	}
+	return "alb_logs_" + sess.Name, nil
+}
+
+// RewriteQuery replaces the first alb_logs in a user's query with the session's table
+func RewriteQuery(query string, sess *session.Session) (string, error) {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(query, "alb_logs", tableName, 1), nil
+}
+
+var memoryLimitPattern = regexp.MustCompile(`(?i)^[0-9]+(\.[0-9]+)?\s*(B|KB|MB|GB|TB|KiB|MiB|GiB|TiB)$`)
+
+func SetThreads(n int) error {
+	if n < 1 {
+		return fmt.Errorf("Thread count must be a positive integer, got %d", n)
+	}
+	threads = n
+	return nil
+}
+
+func SetMemoryLimit(limit string) error {
+	limit = strings.TrimSpace(limit)
+	if !memoryLimitPattern.MatchString(limit) {
+		return fmt.Errorf("Invalid memory limit '%s', expected a size like 512MB or 4GB (units: B, KB, MB, GB, TB, KiB, MiB, GiB, TiB)", limit)
+	}
+	memoryLimit = limit
+	return nil
+}
+
 func Connect(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("duckdb", dbPath)
+	// DuckDB opens an in-memory database for an empty path, it can't parse ":memory:" as a DSN
+	if dbPath == session.InMemoryDBPath {
+		return open("")
+	}
+	return open(dbPath)
+}
+
+func ConnectReadOnly(dbPath string) (*sql.DB, error) {
+	return open(dbPath + "?access_mode=READ_ONLY")
+}
+
+func open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("duckdb", dsn)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to duckdb: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrConnectionFailed, err)
 	}
-	err = configure(db, runtime.NumCPU())
+	err = configure(db, threads, memoryLimit)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to config duckdb: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("%w: %v", ErrConnectionFailed, err)
 	}
 	return db, nil
 }
 
-func configure(db *sql.DB, threads int) error {
+func configure(db *sql.DB, threads int, memoryLimit string) error {
 	query := fmt.Sprintf("SET threads=%d;", threads)
 	_, err := db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("Failed to set threads: %v", err)
 	}
+
+	if memoryLimit != "" {
+		query = fmt.Sprintf("SET memory_limit='%s';", memoryLimit)
+		_, err = db.Exec(query)
+		if err != nil {
+			return fmt.Errorf("Failed to set memory limit: %v", err)
+		}
+	}
 	return nil
 }
 
-func InitializeLogTable(db *sql.DB) error {
+func InitializeLogTable(db *sql.DB, logType LogType) error {
 	activeSessions, err := session.GetActiveSession()
 	if err != nil {
-		return fmt.Errorf("Failed to get active session for import: %v", err)
+		return fmt.Errorf("Failed to get active session for import: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return err
 	}
-	tableName := fmt.Sprintf("alb_logs_%s", activeSessions.Name)
 
-	query := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			type VARCHAR,
-			time TIMESTAMP,
-			elb VARCHAR,
-			client VARCHAR,
-			target VARCHAR,
-			request_processing_time FLOAT,
-			target_processing_time FLOAT,
-			response_processing_time FLOAT,
-			elb_status_code INTEGER,
-			target_status_code VARCHAR,
-			received_bytes BIGINT,
-			sent_bytes BIGINT,
-			request VARCHAR,
-			user_agent VARCHAR,
-			ssl_cipher VARCHAR,
-			ssl_protocol VARCHAR,
-			target_group_arn VARCHAR,
-			trace_id VARCHAR,
-			domain_name VARCHAR,
-			chosen_cert_arn VARCHAR,
-			matched_rule_priority VARCHAR,
-			request_creation_time TIMESTAMP,
-			actions_executed VARCHAR,
-			redirect_url VARCHAR,
-			error_reason VARCHAR,
-			target_port_list VARCHAR,
-			target_status_code_list VARCHAR,
-			classification VARCHAR,
-			classification_reason VARCHAR,
-			conn_trace_id VARCHAR,
-			unkown_field_1 VARCHAR,
-			unkown_field_2 VARCHAR,
-			unkown_field_3 VARCHAR,
-		);
-	`, tableName)
+	schema := Schema(logType)
+	if schema == nil {
+		return fmt.Errorf("Unknown log type '%s'", logType)
+	}
+	columns := make([]string, len(schema))
+	for i, column := range schema {
+		columns[i] = fmt.Sprintf("%s %s", column.Name, column.Type)
+	}
+
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (%s);`, tableName, strings.Join(columns, ", "))
 	_, err = db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("Failed to create log table: %v", err)
@@ -85,90 +133,580 @@ func InitializeLogTable(db *sql.DB) error {
 	return nil
 }
 
-func ImportLogFile(db *sql.DB, logFilePath string) error {
+type FileResult struct {
+	Path string
+	Rows int64
+	Err  error
+}
+
+type ImportResult struct {
+	Files   []FileResult
+	Skipped []string
+	// AlreadyImported lists files skipped because the session's ledger has them
+	AlreadyImported []string
+	// DownloadedBytes is the size of the objects downloaded from S3
+	DownloadedBytes int64
+	// Transferred sums the bytes of the imported requests
+	Transferred Transfer
+}
+
+func (r *ImportResult) Add(path string, rows int64, err error) {
+	r.Files = append(r.Files, FileResult{Path: path, Rows: rows, Err: err})
+}
+
+func (r *ImportResult) Rows() int64 {
+	var rows int64
+	for _, file := range r.Files {
+		rows += file.Rows
+	}
+	return rows
+}
+
+func (r *ImportResult) Succeeded() int {
+	succeeded := 0
+	for _, file := range r.Files {
+		if file.Err == nil {
+			succeeded++
+		}
+	}
+	return succeeded
+}
+
+func (r *ImportResult) Failed() []FileResult {
+	var failed []FileResult
+	for _, file := range r.Files {
+		if file.Err != nil {
+			failed = append(failed, file)
+		}
+	}
+	return failed
+}
+
+func ImportLogFile(db *sql.DB, logFilePath string) (int64, error) {
+	return LogFileImporter(DefaultCopyOptions)(db, logFilePath)
+}
+
+func copyLogFile(db *sql.DB, tableName, logFilePath string, options CopyOptions) (int64, error) {
+	compression, empty, err := detectCompression(logFilePath)
+	if err != nil {
+		return 0, err
+	}
+	if empty {
+		return 0, nil
+	}
+
+	// Name the log columns explicitly so that enrichment columns are left NULL. Older log formats
+	// end before conn_trace_id and the reserved trailing fields, NULL_PADDING fills those with NULL.
+	// The CSV sniffer rejects files mixing line lengths, the options and column types say how to parse them
+	columns, err := tableColumns(db, tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`
+		COPY %s (%s) FROM '%s' (%s, HEADER FALSE, NULL_PADDING TRUE, AUTO_DETECT FALSE, COMPRESSION '%s');
+	`, tableName, strings.Join(columns, ", "), strings.ReplaceAll(logFilePath, "'", "''"), options.clause(), compression)
+	debugSQL(query)
+	start := time.Now()
+	result, err := db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to import log file: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get imported row count: %v", err)
+	}
+	logger.Debugf("Imported %d row(s) from '%s' in %s", rows, logFilePath, time.Since(start).Round(time.Millisecond))
+	return rows, nil
+}
+
+// ImportJSONFile loads newline-delimited JSON objects whose keys are column names,
+// keys that aren't columns are ignored and missing keys are imported as NULL
+func ImportJSONFile(db *sql.DB, jsonFilePath string) (int64, error) {
 	activeSessions, err := session.GetActiveSession()
 	if err != nil {
-		return fmt.Errorf("Failed to get active session for import: %v", err)
+		return 0, fmt.Errorf("Failed to get active session for import: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return 0, err
+	}
+
+	compression, empty, err := detectCompression(jsonFilePath)
+	if err != nil {
+		return 0, err
+	}
+	if empty {
+		return 0, nil
+	}
+	// read_json calls it uncompressed where COPY says none
+	if compression == "none" {
+		compression = "uncompressed"
+	}
+
+	schema, err := tableSchema(db, tableName)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, len(schema))
+	types := make([]string, len(schema))
+	for i, column := range schema {
+		names[i] = column.Name
+		types[i] = fmt.Sprintf("'%s': '%s'", column.Name, column.Type)
 	}
-	tableName := fmt.Sprintf("alb_logs_%s", activeSessions.Name)
 
 	query := fmt.Sprintf(`
-		COPY %s FROM '%s' (DELIMITER ' ', HEADER FALSE, QUOTE '"', ESCAPE '"', NULL '-');
-	`, tableName, logFilePath)
-	_, err = db.Exec(query)
+		INSERT INTO %s (%s) SELECT %s FROM read_json('%s', format = 'newline_delimited', compression = '%s', columns = {%s});
+	`, tableName, strings.Join(names, ", "), strings.Join(names, ", "), strings.ReplaceAll(jsonFilePath, "'", "''"), compression, strings.Join(types, ", "))
+	debugSQL(query)
+	start := time.Now()
+	result, err := db.Exec(query)
 	if err != nil {
-		return fmt.Errorf("Failed to import log file: %v", err)
+		return 0, fmt.Errorf("Failed to import JSON file: %v", err)
 	}
 
-	return nil
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get imported row count: %v", err)
+	}
+	logger.Debugf("Imported %d row(s) from '%s' in %s", rows, jsonFilePath, time.Since(start).Round(time.Millisecond))
+	return rows, nil
 }
 
-func ImportDirectoryLogs(db *sql.DB, dirPath string, progressCallback func(current, total int)) error {
-	files, err := os.ReadDir(dirPath)
+func detectCompression(path string) (string, bool, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("Failed to read directory '%s': %v", dirPath, err)
+		return "", false, fmt.Errorf("Failed to open log file: %v", err)
 	}
+	defer file.Close()
 
-	var logFiles []os.DirEntry
-	for _, file := range files {
-		if !file.IsDir() && (strings.HasSuffix(file.Name(), ".log") || strings.HasSuffix(file.Name(), ".log.gz")) {
-			logFiles = append(logFiles, file)
+	magic, err := bufio.NewReader(file).Peek(2)
+	if len(magic) == 0 {
+		if err != nil && err != io.EOF {
+			return "", false, fmt.Errorf("Failed to read log file: %v", err)
 		}
+		return "", true, nil
 	}
-
-	total := len(logFiles)
-	for i, file := range logFiles {
-		filePath := filepath.Join(dirPath, file.Name())
-		err := ImportLogFile(db, filePath)
-		if err != nil {
-			fmt.Printf("Failed to import file '%s': %v\n", filePath, err)
+	if len(magic) == 2 {
+		if magic[0] == 0x1f && magic[1] == 0x8b {
+			return "gzip", false, nil
 		}
-		if progressCallback != nil {
-			progressCallback(i+1, total)
+		return "none", false, nil
+	}
+
+	// Too short to tell by its content, so trust the extension
+	if strings.HasSuffix(path, ".gz") {
+		return "gzip", false, nil
+	}
+	return "none", false, nil
+}
+
+// ImportFiles imports the files with importFile using up to workers concurrent statements,
+// the result lists the files in the given order. Once ctx is done no further files are started,
+// files being imported are finished and the result only lists the files that were started
+func ImportFiles(ctx context.Context, db *sql.DB, paths []string, workers int, importFile func(*sql.DB, string) (int64, error), progressCallback func(current, total int)) *ImportResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	files := make([]FileResult, len(paths))
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	for w := 0; w < workers && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				rows, err := importFile(db, paths[i])
+				files[i] = FileResult{Path: paths[i], Rows: rows, Err: err}
+
+				mu.Lock()
+				done++
+				if progressCallback != nil {
+					progressCallback(done, len(paths))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	started := 0
+dispatch:
+	for ; started < len(paths); started++ {
+		select {
+		case jobs <- started:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
-	return nil
+	close(jobs)
+	wg.Wait()
+
+	return &ImportResult{Files: files[:started]}
 }
 
 func ExecuteQuery(db *sql.DB, query string) (*sql.Rows, error) {
+	debugSQL(query)
+	return db.Query(query)
+}
+
+// QueryColumns returns the columns the query would produce without reading any rows
+func QueryColumns(db *sql.DB, query string) ([]*sql.ColumnType, error) {
+	query = fmt.Sprintf("SELECT * FROM (%s) LIMIT 0", strings.TrimRight(strings.TrimSpace(query), ";"))
+	debugSQL(query)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get column types: %v", err)
+	}
+	return columns, nil
+}
+
+// ExplainAnalyze runs the query and returns DuckDB's profiled plan with per-operator timings
+func ExplainAnalyze(db *sql.DB, query string) (string, error) {
+	debugSQL("EXPLAIN ANALYZE " + query)
+	rows, err := db.Query("EXPLAIN ANALYZE " + query)
+	if err != nil {
+		return "", fmt.Errorf("Failed to profile query: %v", err)
+	}
+	defer rows.Close()
+
+	var plan strings.Builder
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return "", fmt.Errorf("Failed to scan query plan: %v", err)
+		}
+		plan.WriteString(value)
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return plan.String(), nil
+}
+
+func GetByTraceID(db *sql.DB, traceID string) (*sql.Rows, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get active session for trace lookup: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	// The trace_id field carries the whole X-Amzn-Trace-Id header (Root=...;Self=...), so match whole Root or Self ids within it
+	segment := `(^|;)(Root|Self)=` + regexp.QuoteMeta(traceID) + `(;|$)`
+	query := fmt.Sprintf(`
+		SELECT * FROM %s
+		WHERE trace_id = ? OR regexp_matches(trace_id, ?) OR conn_trace_id = ?
+		ORDER BY time;
+	`, tableName)
+	debugSQL(query, traceID, segment, traceID)
+	return db.Query(query, traceID, segment, traceID)
+}
+
+func DescribeTable(db *sql.DB) (*sql.Rows, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get active session for describe: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`DESCRIBE %s;`, tableName)
+	debugSQL(query)
+	return db.Query(query)
+}
+
+func SampleRows(db *sql.DB, n int) (*sql.Rows, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Sample size must be a positive integer, got %d", n)
+	}
+
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get active session for sample: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reservoir sampling reads the table once instead of sorting it like ORDER BY random()
+	query := fmt.Sprintf(`SELECT * FROM %s USING SAMPLE %d ROWS;`, tableName, n)
+	debugSQL(query)
 	return db.Query(query)
 }
 
 func DeleteLogs(db *sql.DB) error {
 	activeSessions, err := session.GetActiveSession()
 	if err != nil {
-		return fmt.Errorf("Failed to get active session for import: %v", err)
+		return fmt.Errorf("Failed to get active session for import: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return err
 	}
-	tableName := fmt.Sprintf("alb_logs_%s", activeSessions.Name)
 
 	query := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, tableName)
 
 	_, err = db.Exec(query)
-	return err
+	if err != nil {
+		return err
+	}
+	return forgetImportedObjects(db, tableName)
+}
+
+func CountRequests(db *sql.DB, filter string) (int64, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get active session for count: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE REGEXP_MATCHES(request, ?);`, tableName)
+	debugSQL(query, filter)
+	err = db.QueryRow(query, filter).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to count requests: %v", err)
+	}
+	return count, nil
+}
+
+type Transfer struct {
+	Received int64
+	Sent     int64
+}
+
+func (t Transfer) Sub(other Transfer) Transfer {
+	return Transfer{Received: t.Received - other.Received, Sent: t.Sent - other.Sent}
+}
+
+// SumTransferredBytes sums received_bytes and sent_bytes over the session's table,
+// the difference of the sums before and after an import is what the import added
+func SumTransferredBytes(db *sql.DB, sess *session.Session) (Transfer, error) {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	var transfer Transfer
+	query := fmt.Sprintf(`SELECT CAST(COALESCE(SUM(received_bytes), 0) AS BIGINT), CAST(COALESCE(SUM(sent_bytes), 0) AS BIGINT) FROM %s;`, tableName)
+	debugSQL(query)
+	if err := db.QueryRow(query).Scan(&transfer.Received, &transfer.Sent); err != nil {
+		return Transfer{}, fmt.Errorf("Failed to sum transferred bytes: %v", err)
+	}
+	return transfer, nil
+}
+
+// PruneBefore deletes all requests logged before t from the session's table and returns how many were deleted
+func PruneBefore(db *sql.DB, t time.Time) (int64, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get active session for prune: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE time < ?;`, tableName)
+	debugSQL(query, t.UTC())
+	result, err := db.Exec(query, t.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune logs: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get pruned row count: %v", err)
+	}
+	return rows, Checkpoint(db)
+}
+
+// Checkpoint writes the write-ahead log into the database file
+func Checkpoint(db *sql.DB) error {
+	if _, err := db.Exec(`CHECKPOINT;`); err != nil {
+		return fmt.Errorf("Failed to checkpoint database: %v", err)
+	}
+	return nil
+}
+
+// TableState summarizes the contents of the session's table, it changes whenever logs are imported
+func TableState(db *sql.DB, sess *session.Session) (string, error) {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return "", err
+	}
+
+	var count int64
+	var maxTime sql.NullTime
+	query := fmt.Sprintf(`SELECT COUNT(*), MAX(time) FROM %s;`, tableName)
+	debugSQL(query)
+	if err := db.QueryRow(query).Scan(&count, &maxTime); err != nil {
+		return "", fmt.Errorf("Failed to read table state: %v", err)
+	}
+	return fmt.Sprintf("%d|%s", count, maxTime.Time.Format(time.RFC3339Nano)), nil
+}
+
+type StatsFilter struct {
+	Requests []string
+	Excludes []string
+	// FilterColumn is the column Requests and Excludes are matched against, empty means request.
+	// It ends up in the SQL, so callers must check it with validColumn first
+	FilterColumn        string
+	MatchedRulePriority string
+	Action              string
+	SSLCipher           string
+	// TargetStatusCode matches target_status_code or any code in target_status_code_list,
+	// which holds one code per target when a request was sent to several targets
+	TargetStatusCode string
+	// From and To restrict requests to [From, To), zero values leave that side open
+	From time.Time
+	To   time.Time
+}
+
+func (f StatsFilter) where() (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	column := "request"
+	if f.FilterColumn != "" && f.FilterColumn != "request" {
+		// Other columns may be numeric or timestamps
+		column = fmt.Sprintf("CAST(%s AS VARCHAR)", f.FilterColumn)
+	}
+	for _, pattern := range f.Requests {
+		conditions = append(conditions, fmt.Sprintf("REGEXP_MATCHES(%s, ?)", column))
+		args = append(args, pattern)
+	}
+	for _, pattern := range f.Excludes {
+		conditions = append(conditions, fmt.Sprintf("NOT REGEXP_MATCHES(%s, ?)", column))
+		args = append(args, pattern)
+	}
+	if f.MatchedRulePriority != "" {
+		conditions = append(conditions, "matched_rule_priority = ?")
+		args = append(args, f.MatchedRulePriority)
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "contains(actions_executed, ?)")
+		args = append(args, f.Action)
+	}
+	if f.SSLCipher != "" {
+		conditions = append(conditions, "REGEXP_MATCHES(ssl_cipher, ?)")
+		args = append(args, f.SSLCipher)
+	}
+	if f.TargetStatusCode != "" {
+		conditions = append(conditions, "(target_status_code = ? OR list_contains(string_split(regexp_replace(trim(target_status_code_list), '[ ,]+', ',', 'g'), ','), ?))")
+		args = append(args, f.TargetStatusCode, f.TargetStatusCode)
+	}
+	// Log timestamps are stored in UTC without a time zone
+	if !f.From.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "time < ?")
+		args = append(args, f.To.UTC())
+	}
+	return strings.Join(conditions, " AND "), args
 }
 
-func GetFilteredStats(db *sql.DB, filter string) (*sql.Rows, error) {
+var StatsIntervals = []string{"second", "minute", "hour", "day"}
+
+// GetFilteredStats aggregates latencies per time bucket of the interval, or per value of the groupBy column if it is set.
+// Requests whose target_processing_time is ALB's -1 sentinel are counted but left out of the latencies
+func GetFilteredStats(db *sql.DB, filter StatsFilter, interval string, groupBy string) (*sql.Rows, error) {
+	if !slices.Contains(StatsIntervals, interval) {
+		return nil, fmt.Errorf("Invalid interval '%s', use one of: %s", interval, strings.Join(StatsIntervals, ", "))
+	}
+
 	activeSessions, err := session.GetActiveSession()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get active session for import: %v", err)
+		return nil, fmt.Errorf("Failed to get active session for import: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.FilterColumn != "" {
+		if err := validColumn(db, tableName, filter.FilterColumn, "filter on"); err != nil {
+			return nil, err
+		}
+	}
+	where, args := filter.where()
+	if groupBy != "" {
+		if err := validColumn(db, tableName, groupBy, "group by"); err != nil {
+			return nil, err
+		}
+		return getGroupedStats(db, tableName, where, args, groupBy)
 	}
-	tableName := fmt.Sprintf("alb_logs_%s", activeSessions.Name)
 
 	query := fmt.Sprintf(`
 	SELECT
-            DATE_TRUNC('minute', time) AS minute,
+            DATE_TRUNC('%s', time) AS %s,
             COUNT(*) AS requests,
-            MIN(target_processing_time) AS min_response_time,
-            MAX(target_processing_time) AS max_response_time,
-            AVG(target_processing_time) AS avg_response_time
+            MIN(target_processing_time) FILTER (WHERE target_processing_time >= 0) AS min_response_time,
+            MAX(target_processing_time) FILTER (WHERE target_processing_time >= 0) AS max_response_time,
+            AVG(target_processing_time) FILTER (WHERE target_processing_time >= 0) AS avg_response_time
         FROM
             %s
-	WHERE REGEXP_MATCHES(request, '%s')
+	WHERE %s
 	GROUP BY
-            minute
+            1
         ORDER BY
-            minute;
-	`, tableName, filter)
+            1;
+	`, interval, interval, tableName, where)
 
-	return db.Query(query)
+	debugSQL(query, args...)
+	return db.Query(query, args...)
+}
+
+// validColumn checks a column name that ends up in the SQL against the table's columns,
+// enrichment columns only count once enrichment has added them
+func validColumn(db *sql.DB, tableName, column, purpose string) error {
+	schema, err := allTableColumns(db, tableName)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, len(schema))
+	for i, c := range schema {
+		columns[i] = c.Name
+	}
+	if !slices.Contains(columns, column) {
+		return fmt.Errorf("Unknown column '%s' to %s, use one of: %s", column, purpose, strings.Join(columns, ", "))
+	}
+	return nil
+}
+
+func getGroupedStats(db *sql.DB, tableName, where string, args []interface{}, groupBy string) (*sql.Rows, error) {
+	query := fmt.Sprintf(`
+	SELECT
+            %s,
+            COUNT(*) AS requests,
+            MIN(target_processing_time) FILTER (WHERE target_processing_time >= 0) AS min_response_time,
+            MAX(target_processing_time) FILTER (WHERE target_processing_time >= 0) AS max_response_time,
+            AVG(target_processing_time) FILTER (WHERE target_processing_time >= 0) AS avg_response_time
+        FROM
+            %s
+	WHERE %s
+	GROUP BY
+            1
+        ORDER BY
+            requests DESC, 1;
+	`, groupBy, tableName, where)
+
+	debugSQL(query, args...)
+	return db.Query(query, args...)
 }
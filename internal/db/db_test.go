@@ -0,0 +1,352 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// newTestSession creates an active session with an ALB log table in a temporary directory
+func newTestSession(t testing.TB) (*session.Session, *sql.DB) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	if err := session.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	if err := session.CreateSession("test", filepath.Join(dir, "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := session.GetActiveSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbConn, err := Connect(sess.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	if err := InitializeLogTable(dbConn, LogTypeALB); err != nil {
+		t.Fatal(err)
+	}
+	return sess, dbConn
+}
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func gzipped(t *testing.T, content []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestConnectInMemory(t *testing.T) {
+	dbConn, err := Connect(session.InMemoryDBPath)
+	if err != nil {
+		t.Fatalf("Connect(%q): %v", session.InMemoryDBPath, err)
+	}
+	defer dbConn.Close()
+
+	if _, err := dbConn.Exec(`CREATE TABLE t (i INTEGER)`); err != nil {
+		t.Fatalf("In-memory database is not writable: %v", err)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	sample, err := os.ReadFile("../../testdata/sample.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := gzipped(t, sample)
+
+	for _, tc := range []struct {
+		name        string
+		file        string
+		content     []byte
+		compression string
+		empty       bool
+	}{
+		{"empty file", "empty.log", nil, "", true},
+		{"empty gz file", "empty.log.gz", nil, "", true},
+		{"one plain byte", "one.log", []byte("h"), "none", false},
+		{"one byte of gzip magic", "one.log", []byte{0x1f}, "none", false},
+		{"one byte with gz extension", "one.log.gz", []byte{0x1f}, "gzip", false},
+		{"plain text", "plain.log", sample, "none", false},
+		{"plain text with gz extension", "plain.log.gz", sample, "none", false},
+		{"gzip", "log.gz", compressed, "gzip", false},
+		{"gzip without extension", "log", compressed, "gzip", false},
+		{"truncated gzip", "truncated.log.gz", compressed[:len(compressed)/2], "gzip", false},
+		{"gzip magic only", "magic.log.gz", compressed[:2], "gzip", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestFile(t, tc.file, tc.content)
+			compression, empty, err := detectCompression(path)
+			if err != nil {
+				t.Fatalf("detectCompression: %v", err)
+			}
+			if compression != tc.compression || empty != tc.empty {
+				t.Errorf("detectCompression = %q, empty %t, want %q, empty %t", compression, empty, tc.compression, tc.empty)
+			}
+		})
+	}
+
+	if _, _, err := detectCompression(filepath.Join(t.TempDir(), "missing.log")); err == nil {
+		t.Error("detectCompression of a missing file succeeded")
+	}
+}
+
+func TestImportEdgeCaseFiles(t *testing.T) {
+	_, dbConn := newTestSession(t)
+	sample, err := os.ReadFile("../../testdata/sample.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := gzipped(t, sample)
+
+	for _, tc := range []struct {
+		name    string
+		file    string
+		content []byte
+		rows    int64
+		wantErr bool
+	}{
+		{"empty file", "empty.log", nil, 0, false},
+		{"empty gz file", "empty.log.gz", nil, 0, false},
+		{"truncated gzip", "truncated.log.gz", compressed[:len(compressed)/2], 0, true},
+		{"one byte with gz extension", "one.log.gz", []byte{0x1f}, 0, true},
+		{"gzip", "sample.log.gz", compressed, 20, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := ImportLogFile(dbConn, writeTestFile(t, tc.file, tc.content))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ImportLogFile error = %v, want error %t", err, tc.wantErr)
+			}
+			if rows != tc.rows {
+				t.Errorf("ImportLogFile imported %d row(s), want %d", rows, tc.rows)
+			}
+		})
+	}
+}
+
+func scanTestRows(t *testing.T, rows *sql.Rows) ([]string, [][]interface{}) {
+	t.Helper()
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records [][]interface{}
+	for rows.Next() {
+		record := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range record {
+			pointers[i] = &record[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return columns, records
+}
+
+func TestImportPathsWithQuotes(t *testing.T) {
+	_, dbConn := newTestSession(t)
+	sample, err := os.ReadFile("../../testdata/sample.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "o'brien's logs")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "it's.log")
+	if err := os.WriteFile(logPath, sample, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if rows, err := ImportLogFile(dbConn, logPath); err != nil || rows != 20 {
+		t.Errorf("ImportLogFile = %d, %v, want 20 rows", rows, err)
+	}
+	if validation, err := ValidateLogFile(dbConn, logPath, LogTypeALB); err != nil || validation.Parsed != 20 {
+		t.Errorf("ValidateLogFile = %+v, %v, want 20 parsed lines", validation, err)
+	}
+}
+
+func TestImportJSONFixture(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample, err := os.ReadFile("../../testdata/sample.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"../../testdata/sample.jsonl", writeTestFile(t, "sample.jsonl.gz", gzipped(t, sample))} {
+		if rows, err := ImportJSONFile(dbConn, path); err != nil || rows != 5 {
+			t.Fatalf("ImportJSONFile(%s) = %d, %v, want 5 rows", path, rows, err)
+		}
+	}
+	if _, err := ImportLogFile(dbConn, "../../testdata/sample.log"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fixture holds the first five lines of sample.log, so each of them is in the table three times
+	var matching int
+	err = dbConn.QueryRow(`SELECT COUNT(*) FROM (SELECT time, client, request, elb_status_code, target_processing_time, sent_bytes, ssl_cipher, conn_trace_id
+		FROM ` + tableName + ` GROUP BY ALL HAVING COUNT(*) = 3)`).Scan(&matching)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matching != 5 {
+		t.Errorf("%d JSON row(s) match their log line, want 5", matching)
+	}
+
+	// The first line leaves out its NULL fields, the third one has a key that isn't a column
+	var cipher sql.NullString
+	var status int
+	err = dbConn.QueryRow(`SELECT ssl_cipher, elb_status_code FROM `+tableName+` ORDER BY time LIMIT 1`).Scan(&cipher, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cipher.Valid || status != 200 {
+		t.Errorf("First row has ssl_cipher %v and status %d, want NULL and 200", cipher, status)
+	}
+}
+
+func TestImportLogFormatVersions(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The current format ends with conn_trace_id, older ones end before it or before the classification
+	rows, err := ImportLogFile(dbConn, "../../testdata/versions.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 {
+		t.Fatalf("Imported %d row(s), want 3", rows)
+	}
+	validation, err := ValidateLogFile(dbConn, "../../testdata/versions.log", LogTypeALB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validation.Parsed != 3 || validation.Malformed != 0 {
+		t.Errorf("ValidateLogFile = %+v, want 3 well-formed lines", validation)
+	}
+
+	result, err := dbConn.Query(`SELECT request, target_status_code_list, classification, conn_trace_id, unknown_field_1 FROM ` + tableName + ` ORDER BY time DESC`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, records := scanTestRows(t, result)
+	want := [][]interface{}{
+		{"GET https://www.example.com:443/current HTTP/1.1", "200", "Acceptable", "TID_c7a2f5e4b1d04a6c9e8f7a6b5c4d3e2f", nil},
+		{"GET https://www.example.com:443/classified HTTP/1.1", "404", "Ambiguous", nil, nil},
+		{"POST http://api.example.com:80/legacy HTTP/1.1", "502", nil, nil, nil},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Errorf("Row %d column %d is %v, want %v", i, j, records[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestGetByTraceIDMatchesWholeIDs(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dbConn.Exec(`INSERT INTO ` + tableName + ` (time, request, trace_id) VALUES
+		('2024-01-01T00:00:01Z', 'root', 'Root=1-58337384-6d4f394fef9e28a8799b7bbd'),
+		('2024-01-01T00:00:02Z', 'self', 'Self=1-58337384-6d4f394fef9e28a8799b7bbd;Root=1-58337385-1234567890abcdef12345678'),
+		('2024-01-01T00:00:03Z', 'longer', 'Root=1-58337384-6d4f394fef9e28a8799b7bbdff'),
+		('2024-01-01T00:00:04Z', 'field', 'Root=1-58337386-fedcba0987654321fedcba09;Sampled=1-58337384-6d4f394fef9e28a8799b7bbd')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"1-58337384-6d4f394fef9e28a8799b7bbd": {"root", "self"},
+		"1-58337385-1234567890abcdef12345678": {"self"},
+		"1-58337384":                          nil,
+		"1-58337384-6d4f394fef9e28a8799b7bb.": nil,
+	}
+	for traceID, want := range tests {
+		rows, err := GetByTraceID(dbConn, traceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, records := scanTestRows(t, rows)
+		var got []string
+		for _, record := range records {
+			for i, column := range columns {
+				if column == "request" {
+					got = append(got, record[i].(string))
+				}
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("GetByTraceID(%s) = %v, want %v", traceID, got, want)
+		}
+	}
+}
+
+func TestStatsEnrichmentColumnsNeedEnrichment(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportLogFile(dbConn, "../../testdata/sample.log"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = GetFilteredStats(dbConn, StatsFilter{}, "day", "country")
+	if err == nil || !strings.Contains(err.Error(), "Unknown column 'country'") {
+		t.Errorf("Grouping by country before enrichment returned %v, want an unknown column error", err)
+	}
+
+	for _, column := range enrichmentColumns {
+		if _, err := dbConn.Exec(`ALTER TABLE ` + tableName + ` ADD COLUMN ` + column.Name + ` ` + column.Type); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := GetFilteredStats(dbConn, StatsFilter{}, "day", "country")
+	if err != nil {
+		t.Fatalf("Grouping by country after enrichment: %v", err)
+	}
+	rows.Close()
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/frederikmartin/logwarts/internal/logger"
+)
+
+// debugSQL logs a statement on a single line with its bound parameters
+func debugSQL(query string, args ...interface{}) {
+	if !logger.Verbose() {
+		return
+	}
+	statement := strings.Join(strings.Fields(query), " ")
+	if len(args) > 0 {
+		logger.Debugf("SQL: %s -- params: %v", statement, args)
+	} else {
+		logger.Debugf("SQL: %s", statement)
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+var DiffMetrics = []string{"count", "error_rate", "avg_latency", "p95_latency"}
+
+// Latencies leave out the -1 ALB logs if no target was reached
+var diffMetricExpressions = map[string]string{
+	"count":       "CAST(COUNT(*) AS DOUBLE)",
+	"error_rate":  "100.0 * COUNT(*) FILTER (WHERE elb_status_code >= 500) / NULLIF(COUNT(*), 0)",
+	"avg_latency": "AVG(target_processing_time) FILTER (WHERE target_processing_time >= 0)",
+	"p95_latency": "QUANTILE_CONT(target_processing_time, 0.95) FILTER (WHERE target_processing_time >= 0)",
+}
+
+const diffAlias = "logwarts_diff"
+
+// DiffSessions compares metrics of two sessions. The connection must be opened on the base session's
+// database, the other session's database is attached when it lives in a different file
+func DiffSessions(db *sql.DB, base, other *session.Session, metrics []string) (*sql.Rows, error) {
+	if len(metrics) == 0 {
+		metrics = DiffMetrics
+	}
+
+	baseTable, err := TableName(base)
+	if err != nil {
+		return nil, err
+	}
+	otherTable, err := TableName(other)
+	if err != nil {
+		return nil, err
+	}
+
+	if other.DBPath != base.DBPath {
+		query := fmt.Sprintf(`ATTACH '%s' AS %s (READ_ONLY);`, strings.ReplaceAll(other.DBPath, "'", "''"), diffAlias)
+		if _, err := db.Exec(query); err != nil {
+			return nil, fmt.Errorf("Failed to attach database of session '%s': %v", other.Name, err)
+		}
+		otherTable = diffAlias + "." + otherTable
+	}
+
+	var selects []string
+	for _, metric := range metrics {
+		expression, ok := diffMetricExpressions[metric]
+		if !ok {
+			return nil, fmt.Errorf("Unknown metric '%s', use one of: %s", metric, strings.Join(DiffMetrics, ", "))
+		}
+		selects = append(selects, fmt.Sprintf(`
+		SELECT '%s' AS metric, a.v AS "%s", b.v AS "%s", b.v - a.v AS delta, 100.0 * (b.v - a.v) / NULLIF(a.v, 0) AS change_pct
+		FROM (SELECT %s AS v FROM %s) a, (SELECT %s AS v FROM %s) b`,
+			metric, base.Name, other.Name, expression, baseTable, expression, otherTable))
+	}
+
+	query := strings.Join(selects, "\n\t\tUNION ALL") + ";"
+	debugSQL(query)
+	return db.Query(query)
+}
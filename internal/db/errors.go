@@ -0,0 +1,17 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// Errors returned by the package are wrapped around these, callers can check them with errors.Is
+var (
+	ErrNoActiveSession    = session.ErrNoActiveSession
+	ErrConnectionFailed   = errors.New("Failed to connect to duckdb")
+	ErrInvalidSessionName = errors.New("Invalid session name")
+	ErrTableMissing       = errors.New("Log table does not exist")
+	ErrUnknownLogFormat   = errors.New("Log table does not match any known log format")
+	ErrLogTypeMismatch    = errors.New("Log type mismatch")
+)
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+var statusClassPattern = regexp.MustCompile(`^[1-5]xx$`)
+
+// GetFailedRequests lists requests the load balancer or the target answered with anything but 2xx, newest first.
+// statusClass like "5xx" restricts them to one class of either status code. Like GetUserAgents it also
+// returns the number of failed requests without the limit
+func GetFailedRequests(db *sql.DB, filter StatsFilter, statusClass string, limit int) (*sql.Rows, int64, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to get active session for failed requests: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// A target status of '-' means no target answered, which only counts as failed if the load balancer failed
+	where, args := filter.where()
+	if statusClass != "" {
+		if !statusClassPattern.MatchString(statusClass) {
+			return nil, 0, fmt.Errorf("Invalid status class '%s'. Use one of '1xx' to '5xx'", statusClass)
+		}
+		where += " AND (CAST(elb_status_code AS VARCHAR) LIKE ? OR target_status_code LIKE ?)"
+		args = append(args, statusClass[:1]+"%", statusClass[:1]+"%")
+	}
+
+	query := fmt.Sprintf(`
+	SELECT
+            time,
+            request,
+            elb_status_code,
+            target_status_code,
+            target,
+            CASE WHEN LEAST(request_processing_time, target_processing_time, response_processing_time) >= 0
+                THEN request_processing_time + target_processing_time + response_processing_time END AS latency
+        FROM
+            %s
+	WHERE (elb_status_code >= 400 OR (target_status_code NOT LIKE '2%%' AND target_status_code <> '-')) AND %s
+        ORDER BY
+            time DESC`, tableName, where)
+
+	return queryWithLimit(db, query, args, limit)
+}
@@ -0,0 +1,46 @@
+package db
+
+import "testing"
+
+func TestGetFailedRequestsIgnoresMissingTargetStatus(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Imports with another --null or from JSON keep '-' as the target status of requests no target answered
+	_, err = dbConn.Exec(`INSERT INTO ` + tableName + ` (time, request, elb_status_code, target_status_code) VALUES
+		('2024-01-02 00:00:01', 'ok', 200, '200'),
+		('2024-01-02 00:00:02', 'redirected by the load balancer', 301, '-'),
+		('2024-01-02 00:00:03', 'fixed response', 200, '-'),
+		('2024-01-02 00:00:04', 'no target status', 200, NULL),
+		('2024-01-02 00:00:05', 'no target available', 503, '-'),
+		('2024-01-02 00:00:06', 'target failed', 200, '500'),
+		('2024-01-02 00:00:07', 'not found', 404, NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, total, err := GetFailedRequests(dbConn, StatsFilter{}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, records := scanTestRows(t, rows)
+	var requests []string
+	for _, record := range records {
+		for i, column := range columns {
+			if column == "request" {
+				requests = append(requests, record[i].(string))
+			}
+		}
+	}
+	want := []string{"not found", "target failed"}
+	if total != 3 || len(requests) != len(want) {
+		t.Fatalf("Failed requests: %q of %d, want %q of 3", requests, total, want)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("Failed request %d is %q, want %q", i, requests[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package db
+
+// Field describes a column of the log table for tooling built around logwarts
+type Field struct {
+	Name        string
+	Type        string
+	Description string
+	// Nullable is set for fields the load balancer logs as "-" when they don't apply, those are imported as NULL
+	Nullable bool
+}
+
+var fieldDescriptions = map[string]string{
+	"type":                         "Type of request or connection, e.g. http, https, h2, grpcs, ws, wss or tls",
+	"version":                      "Version of the log entry format",
+	"time":                         "Time the load balancer generated the response or closed the connection, in UTC",
+	"elb":                          "Resource ID of the load balancer",
+	"listener":                     "Resource ID of the TLS listener",
+	"client":                       "IP address and port of the client",
+	"destination":                  "IP address and port of the destination",
+	"target":                       "IP address and port of the target that processed the request",
+	"request_processing_time":      "Seconds from receiving the request until sending it to a target, -1 if it wasn't dispatched",
+	"target_processing_time":       "Seconds from sending the request to a target until the target started to respond, -1 if no target responded",
+	"response_processing_time":     "Seconds from receiving the target's response until starting to send it to the client, -1 if the response wasn't sent",
+	"connection_time":              "Milliseconds the connection took to complete",
+	"tls_handshake_time":           "Milliseconds the TLS handshake took",
+	"elb_status_code":              "Status code of the load balancer's response",
+	"target_status_code":           "Status code of the target's response",
+	"received_bytes":               "Size of the request received from the client in bytes",
+	"sent_bytes":                   "Size of the response sent to the client in bytes",
+	"request":                      "Request line from the client: method, URL and protocol version",
+	"user_agent":                   "User-Agent string of the client",
+	"ssl_cipher":                   "TLS cipher negotiated with the client",
+	"ssl_protocol":                 "TLS protocol negotiated with the client",
+	"incoming_tls_alert":           "Integer value of TLS alerts received from the client",
+	"tls_cipher":                   "TLS cipher negotiated with the client",
+	"tls_protocol_version":         "TLS protocol negotiated with the client",
+	"tls_named_group":              "Named group of the TLS key exchange",
+	"tls_connection_creation_time": "Time the TLS connection was established",
+	"alpn_fe_protocol":             "Application protocol negotiated with the client",
+	"alpn_be_protocol":             "Application protocol negotiated with the target",
+	"alpn_client_preference_list":  "Application protocols the client offered, in order of preference",
+	"chosen_cert_serial":           "Serial number of the certificate presented to the client",
+	"target_group_arn":             "ARN of the target group",
+	"trace_id":                     "Contents of the X-Amzn-Trace-Id header",
+	"domain_name":                  "SNI domain the client sent in the TLS handshake",
+	"chosen_cert_arn":              "ARN of the certificate presented to the client",
+	"matched_rule_priority":        "Priority of the listener rule that matched the request, 0 for the default rule",
+	"request_creation_time":        "Time the load balancer received the request from the client, in UTC",
+	"actions_executed":             "Comma-separated actions taken when processing the request, e.g. forward or redirect",
+	"redirect_url":                 "Target URL of a redirect action",
+	"error_reason":                 "Error reason code if the request failed",
+	"target_port_list":             "Space-separated IP addresses and ports of the targets the request was sent to",
+	"target_status_code_list":      "Space-separated status codes of the responses of each target",
+	"classification":               "Desync mitigation classification of the request",
+	"classification_reason":        "Reason for a classification other than Compliant",
+	"conn_trace_id":                "Traceability ID linking access log entries to connection log entries",
+	"unknown_field_1":              "Reserved for fields AWS may add to the log format",
+	"unknown_field_2":              "Reserved for fields AWS may add to the log format",
+	"unknown_field_3":              "Reserved for fields AWS may add to the log format",
+	"country":                      "ISO country code of the client IP, filled by import --geoip",
+	"asn":                          "Autonomous system number of the client IP, filled by import --geoip",
+	"as_org":                       "Organization of the client IP's autonomous system, filled by import --geoip",
+}
+
+// Fields that the load balancer always logs a value for
+var requiredFields = map[string]bool{
+	"type":                     true,
+	"version":                  true,
+	"time":                     true,
+	"elb":                      true,
+	"listener":                 true,
+	"client":                   true,
+	"destination":              true,
+	"request_processing_time":  true,
+	"target_processing_time":   true,
+	"response_processing_time": true,
+	"connection_time":          true,
+	"elb_status_code":          true,
+	"received_bytes":           true,
+	"sent_bytes":               true,
+	"request":                  true,
+	"request_creation_time":    true,
+}
+
+// Fields returns the fields of a log table of the given type, including the enrichment columns
+func Fields(logType LogType) []Field {
+	var fields []Field
+	for _, column := range append(Schema(logType), enrichmentColumns...) {
+		fields = append(fields, Field{
+			Name:        column.Name,
+			Type:        column.Type,
+			Description: fieldDescriptions[column.Name],
+			Nullable:    !requiredFields[column.Name],
+		})
+	}
+	return fields
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// OrphanedTables returns the log tables in the database that belong to none of the sessions
+func OrphanedTables(db *sql.DB, sessions []session.Session) ([]string, error) {
+	known := make(map[string]bool)
+	for i := range sessions {
+		if tableName, err := TableName(&sessions[i]); err == nil {
+			known[tableName] = true
+		}
+	}
+
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables WHERE table_schema = 'main' AND table_name LIKE 'alb\_logs\_%' ESCAPE '\' ORDER BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list log tables: %v", err)
+	}
+	defer rows.Close()
+
+	var orphaned []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("Failed to scan table name: %v", err)
+		}
+		if !known[tableName] {
+			orphaned = append(orphaned, tableName)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return orphaned, nil
+}
+
+// DropOrphanedTable drops a log table returned by OrphanedTables together with its import ledger entries
+func DropOrphanedTable(db *sql.DB, tableName string) error {
+	// The name ends up in DDL, so only names TableName could have produced are accepted
+	if !strings.HasPrefix(tableName, "alb_logs_") || !sessionNamePattern.MatchString(strings.TrimPrefix(tableName, "alb_logs_")) {
+		return fmt.Errorf("'%s' is not a log table", tableName)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, tableName)); err != nil {
+		return fmt.Errorf("Failed to drop table '%s': %v", tableName, err)
+	}
+	return forgetImportedObjects(db, tableName)
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/frederikmartin/logwarts/internal/geoip"
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// The client field is "ip:port", IPv6 addresses are not bracketed
+const clientIP = `REGEXP_EXTRACT(client, '^(.*):[0-9]+$', 1)`
+
+// EnrichGeoIP fills the country, asn and as_org columns of rows that have not been enriched yet
+// and returns the number of distinct client IPs that were resolved
+func EnrichGeoIP(db *sql.DB, readers []*geoip.Reader) (int, error) {
+	if len(readers) == 0 {
+		return 0, nil
+	}
+
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get active session for GeoIP enrichment: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, column := range enrichmentColumns {
+		query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;`, tableName, column.Name, column.Type)
+		if _, err := db.Exec(query); err != nil {
+			return 0, fmt.Errorf("Failed to add column '%s': %v", column.Name, err)
+		}
+	}
+
+	ips, err := unenrichedClientIPs(db, tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	records := make(map[string]geoip.Record)
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+		var merged geoip.Record
+		found := false
+		for _, reader := range readers {
+			record, ok, err := reader.Lookup(parsed)
+			if err != nil {
+				return 0, fmt.Errorf("Failed to look up '%s': %v", ip, err)
+			}
+			if !ok {
+				continue
+			}
+			found = true
+			if merged.Country == "" {
+				merged.Country = record.Country
+			}
+			if merged.ASN == 0 {
+				merged.ASN = record.ASN
+				merged.ASOrg = record.ASOrg
+			}
+		}
+		if found {
+			records[ip] = merged
+		}
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TEMP TABLE logwarts_geoip (ip VARCHAR PRIMARY KEY, country VARCHAR, asn BIGINT, as_org VARCHAR);`)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create GeoIP lookup table: %v", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO logwarts_geoip VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prepare GeoIP insert: %v", err)
+	}
+	defer stmt.Close()
+	for ip, record := range records {
+		country := sql.NullString{String: record.Country, Valid: record.Country != ""}
+		asn := sql.NullInt64{Int64: int64(record.ASN), Valid: record.ASN != 0}
+		asOrg := sql.NullString{String: record.ASOrg, Valid: record.ASOrg != ""}
+		if _, err := stmt.Exec(ip, country, asn, asOrg); err != nil {
+			return 0, fmt.Errorf("Failed to insert GeoIP record for '%s': %v", ip, err)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE %s SET country = g.country, asn = g.asn, as_org = g.as_org
+		FROM logwarts_geoip g
+		WHERE %s = g.ip;
+	`, tableName, clientIP)
+	if _, err := tx.Exec(query); err != nil {
+		return 0, fmt.Errorf("Failed to update GeoIP columns: %v", err)
+	}
+	if _, err := tx.Exec(`DROP TABLE logwarts_geoip;`); err != nil {
+		return 0, fmt.Errorf("Failed to drop GeoIP lookup table: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to commit GeoIP enrichment: %v", err)
+	}
+	return len(records), nil
+}
+
+func unenrichedClientIPs(db *sql.DB, tableName string) ([]string, error) {
+	query := fmt.Sprintf(`
+		SELECT DISTINCT %s FROM %s
+		WHERE client IS NOT NULL AND country IS NULL AND asn IS NULL;
+	`, clientIP, tableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read client IPs: %v", err)
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip sql.NullString
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("Failed to scan client IP: %v", err)
+		}
+		if ip.Valid && ip.String != "" {
+			ips = append(ips, ip.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return ips, nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+var numericColumns = map[string]bool{
+	"request_processing_time":  true,
+	"target_processing_time":   true,
+	"response_processing_time": true,
+	"elb_status_code":          true,
+	"received_bytes":           true,
+	"sent_bytes":               true,
+}
+
+type HistogramBucket struct {
+	Lower float64
+	Upper float64
+	Count int64
+}
+
+func GetHistogram(db *sql.DB, column string, filter string, buckets int, logScale bool) ([]HistogramBucket, error) {
+	if !numericColumns[column] {
+		return nil, fmt.Errorf("Column '%s' is not a numeric log field", column)
+	}
+	if buckets < 1 {
+		return nil, fmt.Errorf("Bucket count must be a positive integer, got %d", buckets)
+	}
+
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get active session for histogram: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Log-scale buckets are only defined for positive values
+	value := fmt.Sprintf("CAST(%s AS DOUBLE)", column)
+	where := fmt.Sprintf("%s IS NOT NULL AND REGEXP_MATCHES(request, ?)", column)
+	if strings.HasSuffix(column, "_processing_time") {
+		// ALB logs -1 for phases that didn't complete
+		where = fmt.Sprintf("%s >= 0 AND REGEXP_MATCHES(request, ?)", column)
+	}
+	if logScale {
+		where = fmt.Sprintf("%s > 0 AND REGEXP_MATCHES(request, ?)", column)
+	}
+
+	var lo, hi sql.NullFloat64
+	query := fmt.Sprintf(`SELECT MIN(%s), MAX(%s) FROM %s WHERE %s`, value, value, tableName, where)
+	debugSQL(query, filter)
+	err = db.QueryRow(query, filter).Scan(&lo, &hi)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get value range of '%s': %v", column, err)
+	}
+	if !lo.Valid || !hi.Valid {
+		return nil, nil
+	}
+
+	scale := func(v float64) float64 { return v }
+	unscale := func(v float64) float64 { return v }
+	scaled := value
+	if logScale {
+		scale, unscale = math.Log, math.Exp
+		scaled = fmt.Sprintf("LN(%s)", value)
+	}
+
+	width := (scale(hi.Float64) - scale(lo.Float64)) / float64(buckets)
+	if width == 0 {
+		buckets = 1
+		width = 1
+	}
+
+	result := make([]HistogramBucket, buckets)
+	for i := range result {
+		result[i].Lower = unscale(scale(lo.Float64) + float64(i)*width)
+		result[i].Upper = unscale(scale(lo.Float64) + float64(i+1)*width)
+	}
+	result[buckets-1].Upper = hi.Float64
+
+	query = fmt.Sprintf(`
+		SELECT
+			LEAST(CAST(FLOOR((%s - ?) / ?) AS INTEGER), ?) AS bucket,
+			COUNT(*) AS count
+		FROM %s
+		WHERE %s
+		GROUP BY bucket
+	`, scaled, tableName, where)
+	debugSQL(query, scale(lo.Float64), width, buckets-1, filter)
+	rows, err := db.Query(query, scale(lo.Float64), width, buckets-1, filter)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compute histogram of '%s': %v", column, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket int
+		var count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, fmt.Errorf("Failed to scan histogram bucket: %v", err)
+		}
+		if bucket >= 0 && bucket < buckets {
+			result[bucket].Count = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// latencyPhases are the processing time columns in the order a request passes through them
+var latencyPhases = []struct {
+	column string
+	alias  string
+}{
+	{"request_processing_time", "request"},
+	{"target_processing_time", "target"},
+	{"response_processing_time", "response"},
+}
+
+// GetLatency shows min, avg and p95 of each processing phase per time bucket of the interval. ALB logs -1
+// for phases that didn't complete, e.g. when no target was reached, those values are left out of the
+// aggregates of their phase while the request is still counted
+func GetLatency(db *sql.DB, filter StatsFilter, interval string) (*sql.Rows, error) {
+	if !slices.Contains(StatsIntervals, interval) {
+		return nil, fmt.Errorf("Invalid interval '%s', use one of: %s", interval, strings.Join(StatsIntervals, ", "))
+	}
+
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get active session for latency: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	var aggregates []string
+	for _, phase := range latencyPhases {
+		completed := fmt.Sprintf("FILTER (WHERE %s >= 0)", phase.column)
+		aggregates = append(aggregates,
+			fmt.Sprintf("MIN(%s) %s AS %s_min", phase.column, completed, phase.alias),
+			fmt.Sprintf("AVG(%s) %s AS %s_avg", phase.column, completed, phase.alias),
+			fmt.Sprintf("QUANTILE_CONT(%s, 0.95) %s AS %s_p95", phase.column, completed, phase.alias))
+	}
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+	SELECT
+            DATE_TRUNC('%s', time) AS %s,
+            COUNT(*) AS requests,
+            %s
+        FROM
+            %s
+	WHERE %s
+	GROUP BY
+            1
+        ORDER BY
+            1;
+	`, interval, interval, strings.Join(aggregates, ",\n            "), tableName, where)
+
+	debugSQL(query, args...)
+	return db.Query(query, args...)
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGetLatencyLeavesOutIncompletePhases(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ALB logs -1 for phases that didn't complete: no target was reached, or the client went away
+	_, err = dbConn.Exec(`INSERT INTO ` + tableName + ` (time, request_processing_time, target_processing_time, response_processing_time) VALUES
+		('2024-01-02 00:05:00', 0.001, 0.100, 0.002),
+		('2024-01-02 00:10:00', 0.003, -1, -1),
+		('2024-01-02 00:15:00', -1, -1, -1),
+		('2024-01-02 00:20:00', 0.002, 0.300, 0.004),
+		('2024-01-02 01:05:00', -1, -1, -1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := GetLatency(dbConn, StatsFilter{}, "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, records := scanTestRows(t, rows)
+	if len(records) != 2 {
+		t.Fatalf("Got %d bucket(s), want 2", len(records))
+	}
+	value := func(record []interface{}, column string) interface{} {
+		for i, name := range columns {
+			if name == column {
+				return record[i]
+			}
+		}
+		t.Fatalf("No column '%s' in %v", column, columns)
+		return nil
+	}
+	// MIN keeps the FLOAT type of the columns, AVG and QUANTILE_CONT return doubles
+	float := func(record []interface{}, column string) (float64, bool) {
+		switch v := value(record, column).(type) {
+		case float32:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+		return 0, false
+	}
+
+	// Requests with incomplete phases are counted, but don't pull the aggregates of those phases to -1
+	first := records[0]
+	if requests := value(first, "requests"); requests != int64(4) {
+		t.Errorf("First bucket has %v request(s), want 4", requests)
+	}
+	for column, want := range map[string]float64{
+		"request_min":  0.001,
+		"request_avg":  0.002,
+		"target_min":   0.100,
+		"target_avg":   0.200,
+		"response_min": 0.002,
+		"response_avg": 0.003,
+	} {
+		got, ok := float(first, column)
+		if !ok || math.Abs(got-want) > 1e-6 {
+			t.Errorf("%s = %v, want %g", column, value(first, column), want)
+		}
+	}
+	if p95, ok := float(first, "target_p95"); !ok || p95 < 0.1 || p95 > 0.3 {
+		t.Errorf("target_p95 = %v, want a value between the completed phases", value(first, "target_p95"))
+	}
+
+	// A bucket without any completed phase still shows its request, with no aggregates
+	second := records[1]
+	if requests := value(second, "requests"); requests != int64(1) {
+		t.Errorf("Second bucket has %v request(s), want 1", requests)
+	}
+	for _, column := range []string{"request_min", "target_avg", "response_p95"} {
+		if got := value(second, column); got != nil {
+			t.Errorf("%s of a bucket without completed phases = %v, want NULL", column, got)
+		}
+	}
+}
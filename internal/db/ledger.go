@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// The ledger remembers which S3 objects and local files were imported into a session's table, so that
+// repeated imports from the same prefix only fetch new or changed objects and interrupted local imports can resume
+func initializeLedger(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS logwarts_imported_objects (
+		table_name VARCHAR NOT NULL,
+		key VARCHAR NOT NULL,
+		etag VARCHAR NOT NULL,
+		imported_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (table_name, key)
+	);`)
+	if err != nil {
+		return fmt.Errorf("Failed to create import ledger: %v", err)
+	}
+	// Ledgers created before --since-last-import lack the S3 LastModified column
+	_, err = db.Exec(`ALTER TABLE logwarts_imported_objects ADD COLUMN IF NOT EXISTS last_modified TIMESTAMP;`)
+	if err != nil {
+		return fmt.Errorf("Failed to migrate import ledger: %v", err)
+	}
+	return nil
+}
+
+// ImportedObjects returns the ETag of every S3 object key imported into the session's table
+func ImportedObjects(db *sql.DB, sess *session.Session) (map[string]string, error) {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return nil, err
+	}
+	if err := initializeLedger(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT key, etag FROM logwarts_imported_objects WHERE table_name = ?`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read import ledger: %v", err)
+	}
+	defer rows.Close()
+
+	objects := make(map[string]string)
+	for rows.Next() {
+		var key, etag string
+		if err := rows.Scan(&key, &etag); err != nil {
+			return nil, fmt.Errorf("Failed to scan import ledger entry: %v", err)
+		}
+		objects[key] = etag
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return objects, nil
+}
+
+// RecordImportedObject records an imported object, a zero lastModified is stored as NULL
+func RecordImportedObject(db *sql.DB, sess *session.Session, key, etag string, lastModified time.Time) error {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return err
+	}
+	if err := initializeLedger(db); err != nil {
+		return err
+	}
+
+	var modified sql.NullTime
+	if !lastModified.IsZero() {
+		modified = sql.NullTime{Time: lastModified.UTC(), Valid: true}
+	}
+	_, err = db.Exec(`INSERT OR REPLACE INTO logwarts_imported_objects (table_name, key, etag, imported_at, last_modified) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)`, tableName, key, etag, modified)
+	if err != nil {
+		return fmt.Errorf("Failed to record imported object '%s': %v", key, err)
+	}
+	return nil
+}
+
+// LastImportedObjectTime returns the latest S3 LastModified imported into the session's table,
+// or the zero time if no S3 object with a known LastModified was imported yet
+func LastImportedObjectTime(db *sql.DB, sess *session.Session) (time.Time, error) {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := initializeLedger(db); err != nil {
+		return time.Time{}, err
+	}
+
+	var lastModified sql.NullTime
+	err = db.QueryRow(`SELECT MAX(last_modified) FROM logwarts_imported_objects WHERE table_name = ? AND NOT starts_with(key, ?)`, tableName, localFilePrefix).Scan(&lastModified)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read last imported object time: %v", err)
+	}
+	if !lastModified.Valid {
+		return time.Time{}, nil
+	}
+	return lastModified.Time.UTC(), nil
+}
+
+// Local files share the ledger with S3 objects, keyed by their absolute path with the file size as ETag
+const localFilePrefix = "file://"
+
+func localFileKey(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve path '%s': %v", path, err)
+	}
+	return localFilePrefix + absPath, nil
+}
+
+// ImportedFiles returns the size of every local file imported into the session's table by absolute path
+func ImportedFiles(db *sql.DB, sess *session.Session) (map[string]int64, error) {
+	objects, err := ImportedObjects(db, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]int64)
+	for key, etag := range objects {
+		path, ok := strings.CutPrefix(key, localFilePrefix)
+		if !ok {
+			continue
+		}
+		size, err := strconv.ParseInt(etag, 10, 64)
+		if err != nil {
+			continue
+		}
+		files[path] = size
+	}
+	return files, nil
+}
+
+// IsImportedFile reports whether the file was imported before with its current size
+func IsImportedFile(files map[string]int64, path string, size int64) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	importedSize, ok := files[absPath]
+	return ok && importedSize == size
+}
+
+func RecordImportedFile(db *sql.DB, sess *session.Session, path string, size int64) error {
+	key, err := localFileKey(path)
+	if err != nil {
+		return err
+	}
+	return RecordImportedObject(db, sess, key, strconv.FormatInt(size, 10), time.Time{})
+}
+
+func forgetImportedObjects(db *sql.DB, tableName string) error {
+	if err := initializeLedger(db); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM logwarts_imported_objects WHERE table_name = ?`, tableName)
+	if err != nil {
+		return fmt.Errorf("Failed to clear import ledger: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// queryWithLimit runs query with LIMIT limit appended, a limit of 0 returns all rows. It also returns
+// the number of rows without the limit, so that callers can tell when the result was truncated
+func queryWithLimit(db *sql.DB, query string, args []interface{}, limit int) (*sql.Rows, int64, error) {
+	if limit < 0 {
+		return nil, 0, fmt.Errorf("Limit must not be negative, got %d", limit)
+	}
+	if limit == 0 {
+		debugSQL(query, args...)
+		rows, err := db.Query(query, args...)
+		return rows, -1, err
+	}
+
+	var total int64
+	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM (%s)`, query)
+	debugSQL(countQuery, args...)
+	if err := db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("Failed to count rows: %v", err)
+	}
+
+	limited := query + "\n\tLIMIT ?"
+	limitedArgs := append(append([]interface{}{}, args...), limit)
+	debugSQL(limited, limitedArgs...)
+	rows, err := db.Query(limited, limitedArgs...)
+	return rows, total, err
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// Migrations are applied in order, a table's schema version is the number of migrations applied to it
+var migrations = []func(db *sql.DB, tableName string) error{
+	renameLegacyColumns,
+	addMissingColumns,
+}
+
+func Migrate(db *sql.DB, sess *session.Session) error {
+	tableName, err := TableName(sess)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS logwarts_schema_versions (table_name VARCHAR PRIMARY KEY, version INTEGER NOT NULL);`)
+	if err != nil {
+		return fmt.Errorf("Failed to create schema version table: %v", err)
+	}
+
+	version := 0
+	err = db.QueryRow(`SELECT version FROM logwarts_schema_versions WHERE table_name = ?`, tableName).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("Failed to read schema version of '%s': %v", tableName, err)
+	}
+
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](db, tableName); err != nil {
+			return fmt.Errorf("Failed to migrate '%s' to schema version %d: %w", tableName, version+1, err)
+		}
+		_, err = db.Exec(`INSERT OR REPLACE INTO logwarts_schema_versions (table_name, version) VALUES (?, ?)`, tableName, version+1)
+		if err != nil {
+			return fmt.Errorf("Failed to record schema version of '%s': %v", tableName, err)
+		}
+	}
+	return nil
+}
+
+func renameLegacyColumns(db *sql.DB, tableName string) error {
+	columns, err := tableColumns(db, tableName)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		newName, ok := legacyColumnNames[column]
+		if !ok {
+			continue
+		}
+		query := fmt.Sprintf(`ALTER TABLE %s RENAME COLUMN %s TO %s;`, tableName, column, newName)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("Failed to rename column '%s' to '%s': %v", column, newName, err)
+		}
+	}
+	return nil
+}
+
+// COPY maps fields by position, so columns can only be added if the table holds a prefix of a known schema
+func addMissingColumns(db *sql.DB, tableName string) error {
+	columns, err := tableColumns(db, tableName)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("%w: %s", ErrTableMissing, tableName)
+	}
+
+	for _, schema := range schemas {
+		if len(columns) > len(schema) || !hasColumnPrefix(schema, columns) {
+			continue
+		}
+		for _, column := range schema[len(columns):] {
+			query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, tableName, column.Name, column.Type)
+			if _, err := db.Exec(query); err != nil {
+				return fmt.Errorf("Failed to add column '%s': %v", column.Name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Table '%s' does not match any known log format", tableName)
+}
+
+func hasColumnPrefix(schema []Column, columns []string) bool {
+	for i, column := range columns {
+		if schema[i].Name != column {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFreshTableHasCorrectedColumnNames(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(dbConn, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	columns, err := tableColumns(dbConn, tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for legacy, corrected := range legacyColumnNames {
+		if slices.Contains(columns, legacy) {
+			t.Errorf("Fresh table has the legacy column '%s'", legacy)
+		}
+		if !slices.Contains(columns, corrected) {
+			t.Errorf("Fresh table lacks the column '%s'", corrected)
+		}
+	}
+}
+
+func TestMigrateRenamesLegacyColumns(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recreate the table as older versions did, with the misspelled names
+	legacyNames := make(map[string]string)
+	for legacy, corrected := range legacyColumnNames {
+		legacyNames[corrected] = legacy
+	}
+	var definitions []string
+	for _, column := range Schema(LogTypeALB) {
+		name := column.Name
+		if legacy, ok := legacyNames[name]; ok {
+			name = legacy
+		}
+		definitions = append(definitions, fmt.Sprintf("%s %s", name, column.Type))
+	}
+	if _, err := dbConn.Exec(fmt.Sprintf(`DROP TABLE %s; CREATE TABLE %s (%s);`, tableName, tableName, strings.Join(definitions, ", "))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(dbConn, sess); err != nil {
+		t.Fatal(err)
+	}
+	columns, err := tableColumns(dbConn, tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, column := range Schema(LogTypeALB) {
+		want = append(want, column.Name)
+	}
+	if !slices.Equal(columns, want) {
+		t.Errorf("Columns after migrating:\n%v\nwant\n%v", columns, want)
+	}
+}
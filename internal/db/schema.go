@@ -0,0 +1,232 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+type LogType string
+
+const (
+	LogTypeALB LogType = "alb"
+	LogTypeNLB LogType = "nlb"
+	LogTypeCLB LogType = "clb"
+)
+
+type Column struct {
+	Name string
+	Type string
+}
+
+var legacyColumnNames = map[string]string{
+	"unkown_field_1": "unknown_field_1",
+	"unkown_field_2": "unknown_field_2",
+	"unkown_field_3": "unknown_field_3",
+}
+
+var schemas = map[LogType][]Column{
+	LogTypeALB: {
+		{"type", "VARCHAR"},
+		{"time", "TIMESTAMP"},
+		{"elb", "VARCHAR"},
+		{"client", "VARCHAR"},
+		{"target", "VARCHAR"},
+		{"request_processing_time", "FLOAT"},
+		{"target_processing_time", "FLOAT"},
+		{"response_processing_time", "FLOAT"},
+		{"elb_status_code", "INTEGER"},
+		{"target_status_code", "VARCHAR"},
+		{"received_bytes", "BIGINT"},
+		{"sent_bytes", "BIGINT"},
+		{"request", "VARCHAR"},
+		{"user_agent", "VARCHAR"},
+		{"ssl_cipher", "VARCHAR"},
+		{"ssl_protocol", "VARCHAR"},
+		{"target_group_arn", "VARCHAR"},
+		{"trace_id", "VARCHAR"},
+		{"domain_name", "VARCHAR"},
+		{"chosen_cert_arn", "VARCHAR"},
+		{"matched_rule_priority", "VARCHAR"},
+		{"request_creation_time", "TIMESTAMP"},
+		{"actions_executed", "VARCHAR"},
+		{"redirect_url", "VARCHAR"},
+		{"error_reason", "VARCHAR"},
+		{"target_port_list", "VARCHAR"},
+		{"target_status_code_list", "VARCHAR"},
+		{"classification", "VARCHAR"},
+		{"classification_reason", "VARCHAR"},
+		{"conn_trace_id", "VARCHAR"},
+		{"unknown_field_1", "VARCHAR"},
+		{"unknown_field_2", "VARCHAR"},
+		{"unknown_field_3", "VARCHAR"},
+	},
+	LogTypeNLB: {
+		{"type", "VARCHAR"},
+		{"version", "VARCHAR"},
+		{"time", "TIMESTAMP"},
+		{"elb", "VARCHAR"},
+		{"listener", "VARCHAR"},
+		{"client", "VARCHAR"},
+		{"destination", "VARCHAR"},
+		{"connection_time", "BIGINT"},
+		{"tls_handshake_time", "BIGINT"},
+		{"received_bytes", "BIGINT"},
+		{"sent_bytes", "BIGINT"},
+		{"incoming_tls_alert", "VARCHAR"},
+		{"chosen_cert_arn", "VARCHAR"},
+		{"chosen_cert_serial", "VARCHAR"},
+		{"tls_cipher", "VARCHAR"},
+		{"tls_protocol_version", "VARCHAR"},
+		{"tls_named_group", "VARCHAR"},
+		{"domain_name", "VARCHAR"},
+		{"alpn_fe_protocol", "VARCHAR"},
+		{"alpn_be_protocol", "VARCHAR"},
+		{"alpn_client_preference_list", "VARCHAR"},
+		{"tls_connection_creation_time", "TIMESTAMP"},
+	},
+	// Classic Load Balancer fields use the names of their ALB counterparts (backend -> target)
+	// so stats and queries work the same way for both
+	LogTypeCLB: {
+		{"time", "TIMESTAMP"},
+		{"elb", "VARCHAR"},
+		{"client", "VARCHAR"},
+		{"target", "VARCHAR"},
+		{"request_processing_time", "FLOAT"},
+		{"target_processing_time", "FLOAT"},
+		{"response_processing_time", "FLOAT"},
+		{"elb_status_code", "INTEGER"},
+		{"target_status_code", "VARCHAR"},
+		{"received_bytes", "BIGINT"},
+		{"sent_bytes", "BIGINT"},
+		{"request", "VARCHAR"},
+		{"user_agent", "VARCHAR"},
+		{"ssl_cipher", "VARCHAR"},
+		{"ssl_protocol", "VARCHAR"},
+	},
+}
+
+func ParseLogType(logType string) (LogType, error) {
+	t := LogType(strings.ToLower(logType))
+	if _, ok := schemas[t]; !ok {
+		return "", fmt.Errorf("Unknown log type '%s'. Use 'alb', 'nlb', or 'clb'", logType)
+	}
+	return t, nil
+}
+
+func Schema(logType LogType) []Column {
+	return schemas[logType]
+}
+
+func DetectLogType(db *sql.DB) (LogType, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get active session: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return "", err
+	}
+
+	columns, err := tableColumns(db, tableName)
+	if err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrTableMissing, tableName)
+	}
+
+	for logType, schema := range schemas {
+		if len(schema) != len(columns) {
+			continue
+		}
+		matches := true
+		for i, column := range schema {
+			if column.Name != columns[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return logType, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownLogFormat, tableName)
+}
+
+func CheckLogType(db *sql.DB, logType LogType) error {
+	tableType, err := DetectLogType(db)
+	if err != nil {
+		return err
+	}
+	if tableType != logType {
+		return fmt.Errorf("%w: session holds %s logs, refusing to import %s logs into it", ErrLogTypeMismatch, tableType, logType)
+	}
+	return nil
+}
+
+// Columns added by enrichment are not part of the log files and are skipped when matching a table against a schema
+var enrichmentColumns = []Column{
+	{"country", "VARCHAR"},
+	{"asn", "BIGINT"},
+	{"as_org", "VARCHAR"},
+}
+
+func isEnrichmentColumn(name string) bool {
+	for _, column := range enrichmentColumns {
+		if column.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tableColumns(db *sql.DB, tableName string) ([]string, error) {
+	schema, err := tableSchema(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(schema))
+	for i, column := range schema {
+		columns[i] = column.Name
+	}
+	return columns, nil
+}
+
+func tableSchema(db *sql.DB, tableName string) ([]Column, error) {
+	all, err := allTableColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	var columns []Column
+	for _, column := range all {
+		if !isEnrichmentColumn(column.Name) {
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// allTableColumns reads every column of the table, including the enrichment columns that were added to it
+func allTableColumns(db *sql.DB, tableName string) ([]Column, error) {
+	rows, err := db.Query(`SELECT column_name, data_type FROM information_schema.columns WHERE table_name = ? ORDER BY ordinal_position`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read columns of '%s': %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		if err := rows.Scan(&column.Name, &column.Type); err != nil {
+			return nil, fmt.Errorf("Failed to scan column name: %v", err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return columns, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// Every path reads and writes the table TableName returns, so what one imports the others see
+func TestTableNameCallSitesAgree(t *testing.T) {
+	sess, dbConn := newTestSession(t)
+	tableName, err := TableName(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tableName != "alb_logs_test" {
+		t.Errorf("TableName = %q, want alb_logs_test", tableName)
+	}
+
+	imported, err := ImportLogFile(dbConn, "../../testdata/sample.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported == 0 {
+		t.Fatal("Imported no rows from the sample log")
+	}
+
+	query, err := RewriteQuery("SELECT COUNT(*) FROM alb_logs", sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queried int64
+	if err := dbConn.QueryRow(query).Scan(&queried); err != nil {
+		t.Fatalf("Rewritten query %q: %v", query, err)
+	}
+
+	counted, err := CountRequests(dbConn, ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := GetFilteredStats(dbConn, StatsFilter{}, "day", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inStats int64
+	_, records := scanTestRows(t, rows)
+	for _, record := range records {
+		inStats += record[1].(int64)
+	}
+
+	if queried != imported || counted != imported || inStats != imported {
+		t.Errorf("Imported %d row(s), but the query rewrite sees %d, count %d and stats %d", imported, queried, counted, inStats)
+	}
+
+	orphaned, err := OrphanedTables(dbConn, []session.Session{*sess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 0 {
+		t.Errorf("OrphanedTables reports %v for a table that belongs to the session", orphaned)
+	}
+
+	if err := RecordImportedFile(dbConn, sess, "../../testdata/sample.log", 1); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ImportedFiles(dbConn, sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("The ledger has %d file(s) for the session's table, want 1", len(files))
+	}
+}
+
+func TestTableNameRejectsUnsafeNames(t *testing.T) {
+	for _, name := range []string{"", "Upper", "1st", "a-b", "a;DROP TABLE x", "a b"} {
+		if _, err := TableName(&session.Session{Name: name}); err == nil {
+			t.Errorf("TableName accepted the session name %q", name)
+		}
+		if _, err := RewriteQuery("SELECT * FROM alb_logs", &session.Session{Name: name}); err == nil {
+			t.Errorf("RewriteQuery accepted the session name %q", name)
+		}
+	}
+}
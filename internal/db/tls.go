@@ -0,0 +1,41 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/frederikmartin/logwarts/internal/session"
+)
+
+// ALB reports these protocol names, and both were deprecated by RFC 8996
+var deprecatedTLSProtocols = []string{"TLSv1", "TLSv1.1"}
+
+func GetTLSReport(db *sql.DB, filter StatsFilter) (*sql.Rows, error) {
+	activeSessions, err := session.GetActiveSession()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get active session for tls report: %w", err)
+	}
+	tableName, err := TableName(activeSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+	SELECT
+            ssl_protocol,
+            ssl_cipher,
+            COUNT(*) AS requests,
+            CASE WHEN ssl_protocol IN ('%s', '%s') THEN 'DEPRECATED' ELSE '' END AS warning
+        FROM
+            %s
+	WHERE ssl_protocol <> '-' AND %s
+	GROUP BY
+            ssl_protocol, ssl_cipher
+        ORDER BY
+            requests DESC;
+	`, deprecatedTLSProtocols[0], deprecatedTLSProtocols[1], tableName, where)
+
+	debugSQL(query, args...)
+	return db.Query(query, args...)
+}
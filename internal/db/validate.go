@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type Validation struct {
+	Path      string
+	Lines     int64
+	Parsed    int64
+	Malformed int64
+	// Errors holds the first few malformed lines with DuckDB's reason for rejecting them
+	Errors []LineError
+}
+
+type LineError struct {
+	Line    int64
+	Message string
+}
+
+const maxValidationErrors = 5
+
+// ValidateLogFile parses the file with the schema of logType without importing it and counts the lines that don't match
+func ValidateLogFile(db *sql.DB, path string, logType LogType) (*Validation, error) {
+	validation := &Validation{Path: path}
+	compression, empty, err := detectCompression(path)
+	if err != nil {
+		return nil, err
+	}
+	if empty {
+		return validation, nil
+	}
+
+	columns := make([]string, 0, len(Schema(logType)))
+	for _, column := range Schema(logType) {
+		columns = append(columns, fmt.Sprintf("'%s': '%s'", column.Name, column.Type))
+	}
+
+	// Rejected lines are kept in temporary tables of the connection that scanned the file
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) FROM read_csv('%s', DELIM = ' ', HEADER = FALSE, QUOTE = '"', ESCAPE = '"', NULLSTR = '-', NULL_PADDING = TRUE, COMPRESSION = '%s',
+			COLUMNS = {%s}, IGNORE_ERRORS = TRUE, STORE_REJECTS = TRUE);
+	`, strings.ReplaceAll(path, "'", "''"), compression, strings.Join(columns, ", "))
+	if err := conn.QueryRowContext(ctx, query).Scan(&validation.Parsed); err != nil {
+		return nil, fmt.Errorf("Failed to parse '%s': %v", path, err)
+	}
+
+	err = conn.QueryRowContext(ctx, `SELECT COUNT(DISTINCT line) FROM reject_errors`).Scan(&validation.Malformed)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to count malformed lines: %v", err)
+	}
+	validation.Lines = validation.Parsed + validation.Malformed
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT line, FIRST(error_message ORDER BY column_idx) FROM reject_errors GROUP BY line ORDER BY line LIMIT ?
+	`, maxValidationErrors)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read malformed lines: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lineError LineError
+		if err := rows.Scan(&lineError.Line, &lineError.Message); err != nil {
+			return nil, fmt.Errorf("Failed to scan malformed line: %v", err)
+		}
+		validation.Errors = append(validation.Errors, lineError)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+	return validation, nil
+}
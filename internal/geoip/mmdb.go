@@ -0,0 +1,335 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const dataSectionSeparator = 16
+
+// maxDepth bounds how deeply maps and arrays may nest, so crafted data can't exhaust the stack
+const maxDepth = 64
+
+// Reader is a minimal reader for the MaxMind DB format, see https://maxmind.github.io/MaxMind-DB/
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+type Record struct {
+	Country string
+	ASN     uint64
+	ASOrg   string
+}
+
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read GeoIP database: %v", err)
+	}
+
+	markerAt := bytes.LastIndex(buf, metadataMarker)
+	if markerAt == -1 {
+		return nil, fmt.Errorf("'%s' is not a MaxMind DB file", path)
+	}
+	d := decoder{buf: buf[markerAt+len(metadataMarker):]}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode GeoIP database metadata: %v", err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("GeoIP database metadata is not a map")
+	}
+
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  uint(toUint(metadata["node_count"])),
+		recordSize: uint(toUint(metadata["record_size"])),
+		ipVersion:  uint(toUint(metadata["ip_version"])),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("Unsupported GeoIP record size %d", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(markerAt) {
+		return nil, fmt.Errorf("GeoIP database is truncated")
+	}
+	r.data = buf[treeSize+dataSectionSeparator : markerAt]
+
+	// IPv4 addresses live in the ::/96 subtree of IPv6 databases
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+func (r *Reader) Lookup(ip net.IP) (Record, bool, error) {
+	value, found, err := r.lookup(ip)
+	if err != nil || !found {
+		return Record{}, found, err
+	}
+
+	var record Record
+	fields, _ := value.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := fields[key].(map[string]interface{})
+		if code, ok := country["iso_code"].(string); ok {
+			record.Country = code
+			break
+		}
+	}
+	record.ASN = toUint(fields["autonomous_system_number"])
+	record.ASOrg, _ = fields["autonomous_system_organization"].(string)
+	return record, true, nil
+}
+
+func (r *Reader) lookup(ip net.IP) (interface{}, bool, error) {
+	if ip == nil {
+		return nil, false, fmt.Errorf("Invalid IP address")
+	}
+
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, false, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, false, nil
+	}
+	if node < r.nodeCount {
+		return nil, false, fmt.Errorf("Invalid GeoIP search tree")
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to decode GeoIP record: %v", err)
+	}
+	return value, true, nil
+}
+
+func (r *Reader) record(node uint, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeAt(offset, 0)
+}
+
+func (d *decoder) decodeAt(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("Data is nested deeper than %d levels", maxDepth)
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, fmt.Errorf("Offset %d is outside the data section", offset)
+	}
+	ctrl := d.buf[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	if kind == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The format doesn't allow pointers to pointers, refusing them rules out pointer loops
+		if pointer < uint(len(d.buf)) && d.buf[pointer]>>5 == typePointer {
+			return nil, 0, fmt.Errorf("Pointer at %d points to another pointer", offset-1)
+		}
+		value, _, err := d.decodeAt(pointer, depth+1)
+		return value, next, err
+	}
+
+	if kind == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, fmt.Errorf("Unexpected end of data")
+		}
+		kind = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, fmt.Errorf("Unexpected end of data")
+		}
+		extra := uintFromBytes(d.buf[offset : offset+n])
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	// Every entry takes at least one byte, don't trust a size the data can't hold
+	if (kind == typeMap || kind == typeArray) && size > uint(len(d.buf))-offset {
+		return nil, 0, fmt.Errorf("Unexpected end of data")
+	}
+	switch kind {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decodeAt(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decodeAt(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if k, ok := key.(string); ok {
+				m[k] = value
+			}
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decodeAt(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, fmt.Errorf("Unexpected end of data")
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return b, offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("Invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("Invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		return uint64(uintFromBytes(b)), offset, nil
+	case typeInt32:
+		return int64(int32(uintFromBytes(b))), offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("Unknown data type %d", kind)
+}
+
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, fmt.Errorf("Unexpected end of data")
+	}
+	b := d.buf[offset : offset+n]
+	vvv := uint(ctrl & 0x7)
+
+	var pointer uint
+	switch n {
+	case 1:
+		pointer = vvv<<8 | uintFromBytes(b)
+	case 2:
+		pointer = (vvv<<16 | uintFromBytes(b)) + 2048
+	case 3:
+		pointer = (vvv<<24 | uintFromBytes(b)) + 526336
+	default:
+		pointer = uintFromBytes(b)
+	}
+	return pointer, offset + n, nil
+}
+
+func uintFromBytes(b []byte) uint {
+	var v uint
+	for _, c := range b {
+		v = v<<8 | uint(c)
+	}
+	return v
+}
+
+func toUint(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
@@ -0,0 +1,256 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite testdata/test.mmdb")
+
+const fixturePath = "testdata/test.mmdb"
+
+// The encode helpers write the MaxMind DB data format for the fixture and the malformed data tests
+func encodeControl(kind int, size int) []byte {
+	var ctrl []byte
+	if kind > 7 {
+		ctrl = []byte{byte(min(size, 29)), byte(kind - 7)}
+	} else {
+		ctrl = []byte{byte(kind<<5 | min(size, 29))}
+	}
+	if size >= 29 {
+		ctrl = append(ctrl, byte(size-29))
+	}
+	return ctrl
+}
+
+func encodeString(s string) []byte {
+	return append(encodeControl(typeString, len(s)), s...)
+}
+
+func encodeUint(kind int, v uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, v)
+	b = bytes.TrimLeft(b, "\x00")
+	return append(encodeControl(kind, len(b)), b...)
+}
+
+// encodePointer encodes pointers of up to 11 bits, enough for the test data
+func encodePointer(pointer int) []byte {
+	return []byte{byte(typePointer<<5 | pointer>>8&0x7), byte(pointer)}
+}
+
+// encodeMap encodes a map whose values are already encoded, keys are sorted for a stable fixture
+func encodeMap(fields map[string][]byte) []byte {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b := encodeControl(typeMap, len(fields))
+	for _, key := range keys {
+		b = append(b, encodeString(key)...)
+		b = append(b, fields[key]...)
+	}
+	return b
+}
+
+type testNetwork struct {
+	cidr   string
+	record int // offset of the record in the data section
+}
+
+// buildDatabase writes an IPv6 database with 24 bit records, IPv4 networks go to ::/96 like in MaxMind's databases
+func buildDatabase(t *testing.T, data []byte, networks []testNetwork) []byte {
+	t.Helper()
+	const empty = -1
+	type node struct{ records [2]int }
+	nodes := []node{{records: [2]int{empty, empty}}}
+	// Records below len(nodes) point to nodes, data is marked by dataRef until the node count is known
+	const dataRef = 1 << 20
+
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, bits := ipNet.Mask.Size()
+		ip := ipNet.IP.To16()
+		if bits == 32 {
+			ip = append(make(net.IP, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+		current := 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if i == ones-1 {
+				nodes[current].records[bit] = dataRef + network.record
+				break
+			}
+			next := nodes[current].records[bit]
+			if next == empty {
+				nodes = append(nodes, node{records: [2]int{empty, empty}})
+				next = len(nodes) - 1
+				nodes[current].records[bit] = next
+			}
+			current = next
+		}
+	}
+
+	nodeCount := len(nodes)
+	var buf []byte
+	for _, n := range nodes {
+		for _, record := range n.records {
+			value := record
+			switch {
+			case record == empty:
+				value = nodeCount
+			case record >= dataRef:
+				value = record - dataRef + nodeCount + dataSectionSeparator
+			}
+			buf = append(buf, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, encodeMap(map[string][]byte{
+		"node_count":  encodeUint(typeUint32, uint32(nodeCount)),
+		"record_size": encodeUint(typeUint16, 24),
+		"ip_version":  encodeUint(typeUint16, 6),
+	})...)
+	return buf
+}
+
+// fixtureDatabase has a country and ASN record for an IPv4 network sharing its country through a pointer,
+// and an IPv6 network that only has a registered country
+func fixtureDatabase(t *testing.T) []byte {
+	germany := encodeMap(map[string][]byte{"iso_code": encodeString("DE")})
+	data := append([]byte{}, germany...)
+	withASN := len(data)
+	data = append(data, encodeMap(map[string][]byte{
+		"country":                        encodePointer(0),
+		"autonomous_system_number":       encodeUint(typeUint32, 64500),
+		"autonomous_system_organization": encodeString("Example Networks"),
+	})...)
+	countryOnly := len(data)
+	data = append(data, encodeMap(map[string][]byte{"country": encodePointer(0)})...)
+	registered := len(data)
+	data = append(data, encodeMap(map[string][]byte{
+		"registered_country": encodeMap(map[string][]byte{"iso_code": encodeString("US")}),
+	})...)
+
+	return buildDatabase(t, data, []testNetwork{
+		{"192.0.2.0/24", withASN},
+		{"198.51.100.0/25", countryOnly},
+		{"2001:db8::/32", registered},
+	})
+}
+
+func TestLookupFixture(t *testing.T) {
+	if *update {
+		if err := os.WriteFile(fixturePath, fixtureDatabase(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reader, err := Open(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip    string
+		found bool
+		want  Record
+	}{
+		{"192.0.2.1", true, Record{Country: "DE", ASN: 64500, ASOrg: "Example Networks"}},
+		{"192.0.2.255", true, Record{Country: "DE", ASN: 64500, ASOrg: "Example Networks"}},
+		{"198.51.100.127", true, Record{Country: "DE"}},
+		{"198.51.100.128", false, Record{}},
+		{"203.0.113.1", false, Record{}},
+		{"2001:db8::1", true, Record{Country: "US"}},
+		{"2001:db9::1", false, Record{}},
+	}
+	for _, tt := range tests {
+		record, found, err := reader.Lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Errorf("Lookup(%s): %v", tt.ip, err)
+			continue
+		}
+		if found != tt.found || record != tt.want {
+			t.Errorf("Lookup(%s) = %+v, %v, want %+v, %v", tt.ip, record, found, tt.want, tt.found)
+		}
+	}
+	if _, _, err := reader.Lookup(nil); err == nil {
+		t.Error("Lookup(nil) succeeded")
+	}
+}
+
+func TestOpenRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	valid := fixtureDatabase(t)
+	markerAt := bytes.LastIndex(valid, metadataMarker)
+	files := map[string][]byte{
+		"not a database": []byte("hello"),
+		"truncated tree": append(append([]byte{}, metadataMarker...), valid[markerAt+len(metadataMarker):]...),
+		"bad metadata":   append(append([]byte{}, metadataMarker...), encodeString("metadata")...),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_"))
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(path); err == nil {
+			t.Errorf("Open succeeded for %s", name)
+		}
+	}
+}
+
+func TestDecodeRejectsMalformedData(t *testing.T) {
+	// A map whose value points back to the map itself
+	selfReferencing := append(encodeControl(typeMap, 1), encodeString("a")...)
+	selfReferencing = append(selfReferencing, encodePointer(0)...)
+
+	// Pointers whose targets are pointers, directly or in a loop
+	pointerToPointer := append(encodePointer(2), encodePointer(0)...)
+
+	var deeplyNested []byte
+	for i := 0; i < 10000; i++ {
+		deeplyNested = append(deeplyNested, encodeControl(typeArray, 1)...)
+	}
+	deeplyNested = append(deeplyNested, encodeString("leaf")...)
+
+	tests := map[string][]byte{
+		"self referencing map":  selfReferencing,
+		"pointer to itself":     encodePointer(0),
+		"pointer to pointer":    pointerToPointer,
+		"deeply nested arrays":  deeplyNested,
+		"map larger than data":  append(encodeControl(typeMap, 29), 0xFF),
+		"string past the end":   append(encodeControl(typeString, 10), "short"...),
+		"pointer past the end":  encodePointer(100),
+		"truncated pointer":     encodePointer(0)[:1],
+		"double of wrong width": append(encodeControl(typeDouble, 4), 0, 0, 0, 0),
+	}
+	for name, data := range tests {
+		d := decoder{buf: data}
+		if value, _, err := d.decode(0); err == nil {
+			t.Errorf("Decoding %s succeeded with %v", name, value)
+		}
+	}
+
+	// Nesting up to the limit still decodes
+	var nested []byte
+	for i := 0; i < maxDepth; i++ {
+		nested = append(nested, encodeControl(typeArray, 1)...)
+	}
+	nested = append(nested, encodeString("leaf")...)
+	d := decoder{buf: nested}
+	if _, _, err := d.decode(0); err != nil {
+		t.Errorf("Decoding %d nested arrays: %v", maxDepth, err)
+	}
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	quiet   bool
+	verbose bool
+
+	// output is where every message goes, tests replace it
+	output io.Writer = os.Stderr
+)
+
+func SetQuiet(q bool) {
+	quiet = q
+}
+
+func Quiet() bool {
+	return quiet
+}
+
+func SetVerbose(v bool) {
+	verbose = v
+}
+
+func Verbose() bool {
+	return verbose
+}
+
+// Infof writes progress and status messages to stderr so they stay out of piped results
+func Infof(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+	fmt.Fprintf(output, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(output, format, args...)
+}
+
+// Debugf writes a timestamped line to stderr if verbose logging is enabled, independent of quiet
+func Debugf(format string, args ...interface{}) {
+	if !verbose {
+		return
+	}
+	fmt.Fprintf(output, "%s DEBUG %s\n", time.Now().Format("15:04:05.000"), fmt.Sprintf(format, args...))
+}
+
+// Redact hides a secret in debug output while still showing whether it was set
+func Redact(secret string) string {
+	if secret == "" {
+		return "<unset>"
+	}
+	return "<redacted>"
+}
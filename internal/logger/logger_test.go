@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func captureOutput(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := output
+	output = &buf
+	t.Cleanup(func() {
+		output = previous
+		quiet, verbose = false, false
+	})
+	return &buf
+}
+
+func TestMessagesStayOutOfStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	previous := output
+	output = devNull
+	t.Cleanup(func() {
+		output = previous
+		verbose = false
+	})
+
+	SetVerbose(true)
+	Infof("Downloaded %d files\n", 3)
+	Errorf("Error: %v\n", "boom")
+	Debugf("listing %s", "prefix/")
+	w.Close()
+	written, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 0 {
+		t.Errorf("Log messages were written to stdout: %q", written)
+	}
+	if previous != os.Stderr {
+		t.Error("Messages don't go to stderr by default")
+	}
+}
+
+func TestProgressAndMessagesInterleaveInOrder(t *testing.T) {
+	buf := captureOutput(t)
+	SetVerbose(true)
+	// A progress bar sharing stderr redraws its line, messages must still come out whole and in order
+	for i := 1; i <= 3; i++ {
+		buf.WriteString("\rImporting logs " + strings.Repeat("#", i))
+		Infof("\nImported file %d\n", i)
+		Debugf("file %d done", i)
+	}
+	Errorf("Import interrupted\n")
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "\r") {
+			continue
+		}
+		// Drop the timestamp of debug lines
+		if i := strings.Index(line, " DEBUG "); i >= 0 {
+			line = line[i+1:]
+		}
+		messages = append(messages, line)
+	}
+	want := []string{"Imported file 1", "DEBUG file 1 done", "Imported file 2", "DEBUG file 2 done", "Imported file 3", "DEBUG file 3 done", "Import interrupted"}
+	if strings.Join(messages, "|") != strings.Join(want, "|") {
+		t.Errorf("Got messages %q, want %q", messages, want)
+	}
+}
+
+func TestQuietSilencesOnlyInfo(t *testing.T) {
+	buf := captureOutput(t)
+	SetQuiet(true)
+	Infof("Downloading\n")
+	Errorf("Failed\n")
+	if got := buf.String(); got != "Failed\n" {
+		t.Errorf("Quiet output is %q, want only the error", got)
+	}
+}
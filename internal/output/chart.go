@@ -0,0 +1,45 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+func TerminalWidth() int {
+	width, err := getTerminalWidth()
+	if err != nil || width <= 0 {
+		return 80
+	}
+	return width
+}
+
+func WriteBarChart(labels []string, values []int64, w io.Writer) {
+	labelWidth := 0
+	for _, label := range labels {
+		if len(label) > labelWidth {
+			labelWidth = len(label)
+		}
+	}
+
+	var maxValue int64
+	for _, value := range values {
+		if value > maxValue {
+			maxValue = value
+		}
+	}
+	countWidth := len(fmt.Sprintf("%d", maxValue))
+
+	barWidth := TerminalWidth() - labelWidth - countWidth - 5
+	if barWidth < 10 {
+		barWidth = 10
+	}
+
+	for i, label := range labels {
+		length := 0
+		if maxValue > 0 {
+			length = int(values[i] * int64(barWidth) / maxValue)
+		}
+		fmt.Fprintf(w, "%-*s | %*d %s\n", labelWidth, label, countWidth, values[i], strings.Repeat("#", length))
+	}
+}
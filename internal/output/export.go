@@ -0,0 +1,183 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+type csvWriter struct {
+	writer *csv.Writer
+	opts   Options
+}
+
+func newCSVWriter(w io.Writer, opts Options) *csvWriter {
+	return &csvWriter{writer: csv.NewWriter(w), opts: opts}
+}
+
+func (c *csvWriter) WriteHeader(columns []string) error {
+	if !c.opts.Header {
+		return nil
+	}
+	if err := c.writer.Write(columns); err != nil {
+		return fmt.Errorf("Failed to write CSV header: %v", err)
+	}
+	return nil
+}
+
+func (c *csvWriter) WriteRow(row []interface{}) error {
+	if err := c.writer.Write(formatValues(row, c.opts.Null)); err != nil {
+		return fmt.Errorf("Failed to write CSV row: %v", err)
+	}
+	return nil
+}
+
+func (c *csvWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonWriter writes an array of objects whose keys keep the column order of the result,
+// or with lines one object per line (NDJSON), which stays valid when appended to
+type jsonWriter struct {
+	w       io.Writer
+	columns []string
+	rows    int
+	lines   bool
+}
+
+func (j *jsonWriter) WriteHeader(columns []string) error {
+	j.columns = columns
+	if j.lines {
+		return nil
+	}
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonWriter) WriteRow(row []interface{}) error {
+	separator := ",\n  {"
+	if j.lines {
+		separator = "{"
+	} else if j.rows == 0 {
+		separator = "\n  {"
+	}
+	if _, err := io.WriteString(j.w, separator); err != nil {
+		return err
+	}
+	for i, value := range row {
+		key, err := json.Marshal(j.columns[i])
+		if err != nil {
+			return fmt.Errorf("Failed to encode column name: %v", err)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("Failed to encode value of column '%s': %v", j.columns[i], err)
+		}
+		if i > 0 {
+			if _, err := io.WriteString(j.w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(j.w, "%s:%s", key, encoded); err != nil {
+			return err
+		}
+	}
+	j.rows++
+	end := "}"
+	if j.lines {
+		end = "}\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	if j.lines {
+		return nil
+	}
+	if j.rows > 0 {
+		if _, err := io.WriteString(j.w, "\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
+
+type markdownWriter struct {
+	w    io.Writer
+	opts Options
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+func (m *markdownWriter) writeLine(cells []string) error {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = markdownEscaper.Replace(cell)
+	}
+	_, err := fmt.Fprintf(m.w, "| %s |\n", strings.Join(escaped, " | "))
+	return err
+}
+
+func (m *markdownWriter) WriteHeader(columns []string) error {
+	if err := m.writeLine(columns); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(m.w, "|%s\n", strings.Repeat(" --- |", len(columns)))
+	return err
+}
+
+func (m *markdownWriter) WriteRow(row []interface{}) error {
+	return m.writeLine(formatValues(row, m.opts.Null))
+}
+
+func (m *markdownWriter) Flush() error {
+	return nil
+}
+
+type htmlWriter struct {
+	w    io.Writer
+	opts Options
+	rows int
+}
+
+const htmlCellStyle = "border: 1px solid #ccc; padding: 4px 8px; text-align: left;"
+
+func (h *htmlWriter) WriteHeader(columns []string) error {
+	var b strings.Builder
+	b.WriteString("<table style=\"border-collapse: collapse; font-family: sans-serif; font-size: 13px;\">\n")
+	b.WriteString("  <thead>\n    <tr style=\"background-color: #e8e8e8;\">")
+	for _, column := range columns {
+		fmt.Fprintf(&b, "<th style=\"%s\">%s</th>", htmlCellStyle, html.EscapeString(column))
+	}
+	b.WriteString("</tr>\n  </thead>\n  <tbody>\n")
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *htmlWriter) WriteRow(row []interface{}) error {
+	background := "#ffffff"
+	if h.rows%2 == 1 {
+		background = "#f6f6f6"
+	}
+	h.rows++
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "    <tr style=\"background-color: %s;\">", background)
+	for _, col := range formatValues(row, h.opts.Null) {
+		fmt.Fprintf(&b, "<td style=\"%s\">%s</td>", htmlCellStyle, html.EscapeString(col))
+	}
+	b.WriteString("</tr>\n")
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *htmlWriter) Flush() error {
+	_, err := io.WriteString(h.w, "  </tbody>\n</table>\n")
+	return err
+}
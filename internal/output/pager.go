@@ -0,0 +1,62 @@
+package output
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// -F exits right away if the output fits on one screen, -S scrolls long lines horizontally
+const defaultPager = "less -FRSX"
+
+func StdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// StartPager pipes output through $PAGER. The returned function closes the pipe and waits until
+// the user quits the pager. If the pager can't be started, output goes to stdout directly
+func StartPager() (io.Writer, func() error) {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = defaultPager
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return os.Stdout, func() error { return nil }
+	}
+
+	return &pagerWriter{w: in}, func() error {
+		in.Close()
+		cmd.Wait()
+		return nil
+	}
+}
+
+// pagerWriter discards output once the user has quit the pager instead of failing with a broken pipe
+type pagerWriter struct {
+	w      io.Writer
+	closed bool
+}
+
+func (p *pagerWriter) Write(b []byte) (int, error) {
+	if p.closed {
+		return len(b), nil
+	}
+	n, err := p.w.Write(b)
+	if errors.Is(err, syscall.EPIPE) {
+		p.closed = true
+		return len(b), nil
+	}
+	return n, err
+}
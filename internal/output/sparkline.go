@@ -0,0 +1,61 @@
+package output
+
+import (
+	"os"
+	"strings"
+)
+
+var (
+	sparkLevels      = []rune("▁▂▃▄▅▆▇█")
+	asciiSparkLevels = []rune("_.-~=+*#")
+)
+
+// SupportsUnicode guesses from the locale whether the terminal can display block characters
+func SupportsUnicode() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			value = strings.ToLower(value)
+			return strings.Contains(value, "utf-8") || strings.Contains(value, "utf8")
+		}
+	}
+	return false
+}
+
+// Sparkline draws values with one character per value, or per group of values if there are more than width.
+// Groups show their largest value so that short spikes stay visible. It returns how many values a character covers
+func Sparkline(values []int64, width int, ascii bool) (string, int) {
+	levels := sparkLevels
+	if ascii {
+		levels = asciiSparkLevels
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	perChar := (len(values) + width - 1) / width
+	if perChar < 1 {
+		perChar = 1
+	}
+	var grouped []int64
+	for i := 0; i < len(values); i += perChar {
+		var peak int64
+		for _, value := range values[i:min(i+perChar, len(values))] {
+			peak = max(peak, value)
+		}
+		grouped = append(grouped, peak)
+	}
+
+	var maxValue int64
+	for _, value := range grouped {
+		maxValue = max(maxValue, value)
+	}
+	var b strings.Builder
+	for _, value := range grouped {
+		level := 0
+		if maxValue > 0 {
+			level = int(value * int64(len(levels)-1) / maxValue)
+		}
+		b.WriteRune(levels[level])
+	}
+	return b.String(), perChar
+}
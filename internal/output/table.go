@@ -5,27 +5,37 @@ import (
 	"fmt"
 	"golang.org/x/sys/unix"
 	"golang.org/x/term"
+	"io"
 	"os"
 	"strings"
-	"unicode/utf8"
 )
 
+const minColumnWidth = 3
+
 type Table struct {
-	headers   []string
-	rows      [][]string
-	colWidths []int
-	maxWidth  int
+	headers     []string
+	rows        [][]string
+	footer      []string
+	colWidths   []int
+	maxWidth    int
+	noHeader    bool
+	fixedWidths bool
 }
 
 func NewTable(headers []string) *Table {
 	width, err := getTerminalWidth()
 	width = width - 5
 	if err != nil {
-		fmt.Println("Error getting terminal size:", err)
+		fmt.Fprintln(os.Stderr, "Error getting terminal size:", err)
 		width = 80
 	}
 
-	colWidth := width/len(headers) - 3
+	// Tables with more columns than fit the terminal get wider than the terminal instead of
+	// collapsing, pipe them through a pager like 'less -S' to scroll horizontally
+	colWidth := minColumnWidth
+	if len(headers) > 0 && width/len(headers)-3 > minColumnWidth {
+		colWidth = width/len(headers) - 3
+	}
 	colWidths := make([]int, len(headers))
 	for i := range colWidths {
 		colWidths[i] = colWidth
@@ -44,7 +54,7 @@ func NewTable(headers []string) *Table {
 
 func (t *Table) AddRow(row []string) {
 	if len(row) != len(t.headers) {
-		fmt.Println("Error: row length does not match header length")
+		fmt.Fprintln(os.Stderr, "Error: row length does not match header length")
 		return
 	}
 
@@ -55,7 +65,33 @@ func (t *Table) AddRow(row []string) {
 	t.rows = append(t.rows, row)
 }
 
-func getTerminalWidth() (int, error) {
+func (t *Table) SetFooter(footer []string) {
+	if len(footer) != len(t.headers) {
+		fmt.Fprintln(os.Stderr, "Error: footer length does not match header length")
+		return
+	}
+
+	for i, col := range footer {
+		footer[i] = wrapText(col, t.colWidths[i])
+	}
+
+	t.footer = footer
+}
+
+// HideHeader omits the header row, e.g. when the output is concatenated with other tables
+func (t *Table) HideHeader() {
+	t.noHeader = true
+}
+
+// FixWidths keeps the initial even column widths instead of fitting them to the content
+func (t *Table) FixWidths() {
+	t.fixedWidths = true
+}
+
+// getTerminalWidth is a variable so that tests can render tables for a given terminal width
+var getTerminalWidth = terminalColumns
+
+func terminalColumns() (int, error) {
 	if width, _, err := term.GetSize(int(os.Stdin.Fd())); err == nil {
 		return width, nil
 	}
@@ -68,37 +104,34 @@ func getTerminalWidth() (int, error) {
 	return int(ws.Col), nil
 }
 
-func wrapText(text string, width int) string {
-	if utf8.RuneCountInString(text) <= width {
-		return text
-	}
-
-	var wrapped strings.Builder
-	for len(text) > width {
-		wrapped.WriteString(text[:width])
-		wrapped.WriteString("\n")
-		text = text[width:]
-	}
-	wrapped.WriteString(text)
-
-	return wrapped.String()
+func (t *Table) Render() {
+	t.Write(os.Stdout)
 }
 
-func (t *Table) Render() {
-	t.optimizeColumnWidths()
-	t.rewrapContent()
+func (t *Table) Write(w io.Writer) {
+	if !t.fixedWidths {
+		t.optimizeColumnWidths()
+		t.rewrapContent()
+	}
 
 	separator := t.createSeparator()
 
-	fmt.Println(separator)
-	t.printRow(t.headers)
-	fmt.Println(separator)
+	fmt.Fprintln(w, separator)
+	if !t.noHeader {
+		t.printRow(w, t.headers)
+		fmt.Fprintln(w, separator)
+	}
 
 	for _, row := range t.rows {
-		t.printRow(row)
+		t.printRow(w, row)
 	}
 
-	fmt.Println(separator)
+	fmt.Fprintln(w, separator)
+
+	if t.footer != nil {
+		t.printRow(w, t.footer)
+		fmt.Fprintln(w, separator)
+	}
 }
 
 func (t *Table) optimizeColumnWidths() {
@@ -108,10 +141,14 @@ func (t *Table) optimizeColumnWidths() {
 
 	for i, colWidth := range t.colWidths {
 		maxUsedWidth := 0
-		for _, content := range append([]string{t.headers[i]}, t.getColumnContent(i)...) {
+		values := t.getColumnContent(i)
+		if !t.noHeader {
+			values = append(values, t.headers[i])
+		}
+		for _, content := range values {
 			for _, line := range strings.Split(content, "\n") {
-				if len(line) > maxUsedWidth {
-					maxUsedWidth = len(line)
+				if displayWidth(line) > maxUsedWidth {
+					maxUsedWidth = displayWidth(line)
 				}
 			}
 		}
@@ -165,6 +202,10 @@ func (t *Table) rewrapContent() {
 			t.rows[i][j] = wrapText(unwrappedCol, t.colWidths[j])
 		}
 	}
+
+	for i, col := range t.footer {
+		t.footer[i] = wrapText(unwrapText(col), t.colWidths[i])
+	}
 }
 
 func unwrapText(text string) string {
@@ -176,6 +217,9 @@ func (t *Table) getColumnContent(columnIndex int) []string {
 	for i, row := range t.rows {
 		content[i] = row[columnIndex]
 	}
+	if t.footer != nil {
+		content = append(content, t.footer[columnIndex])
+	}
 	return content
 }
 
@@ -187,7 +231,7 @@ func (t *Table) createSeparator() string {
 	return "+" + strings.Join(parts, "+") + "+"
 }
 
-func (t *Table) printRow(row []string) {
+func (t *Table) printRow(w io.Writer, row []string) {
 	lines := make([][]string, len(row))
 	maxLines := 1
 	for i, col := range row {
@@ -201,11 +245,11 @@ func (t *Table) printRow(row []string) {
 		parts := make([]string, len(row))
 		for j, colLines := range lines {
 			if i < len(colLines) {
-				parts[j] = fmt.Sprintf(" %-*s ", t.colWidths[j], colLines[i])
+				parts[j] = " " + padRight(colLines[i], t.colWidths[j]) + " "
 			} else {
-				parts[j] = fmt.Sprintf(" %-*s ", t.colWidths[j], "")
+				parts[j] = " " + padRight("", t.colWidths[j]) + " "
 			}
 		}
-		fmt.Println("|" + strings.Join(parts, "|") + "|")
+		fmt.Fprintln(w, "|"+strings.Join(parts, "|")+"|")
 	}
 }
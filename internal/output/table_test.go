@@ -0,0 +1,93 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func withTerminalWidth(t *testing.T, width int) {
+	t.Helper()
+	previous := getTerminalWidth
+	getTerminalWidth = func() (int, error) { return width, nil }
+	t.Cleanup(func() { getTerminalWidth = previous })
+}
+
+func TestTableFitsNarrowTerminal(t *testing.T) {
+	const width = 40
+	withTerminalWidth(t, width)
+
+	rows := [][]string{
+		{"2024-01-02T00:00:00.000000Z", "GET https://www.example.com:443/api/v1/orders?page=2&sort=desc HTTP/1.1", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"},
+		{"2024-01-02T00:00:01.000000Z", "GET https://例え.jp:443/検索?q=東京タワーの営業時間 HTTP/2.0", "日本語のユーザーエージェント"},
+		{"2024-01-02T00:00:02.000000Z", "POST https://emoji.example.com/🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀 HTTP/1.1", "👩‍💻 bot/1.0"},
+		{"-", strings.Repeat("x", 200), ""},
+	}
+	for _, fixed := range []bool{false, true} {
+		table := NewTable([]string{"time", "request", "user_agent"})
+		if fixed {
+			table.FixWidths()
+		}
+		for _, row := range rows {
+			table.AddRow(append([]string(nil), row...))
+		}
+		table.SetFooter([]string{"total", "4 requests", ""})
+
+		var buf bytes.Buffer
+		table.Write(&buf)
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			if displayWidth(line) > width {
+				t.Errorf("Line is %d columns wide on a %d column terminal (fixed widths: %t): %s", displayWidth(line), width, fixed, line)
+			}
+		}
+	}
+}
+
+func TestTableOnTinyTerminal(t *testing.T) {
+	// Columns keep a minimal width, the table gets wider than the terminal instead of collapsing
+	withTerminalWidth(t, 4)
+	table := NewTable([]string{"status", "count"})
+	table.AddRow([]string{"200", "12345"})
+
+	var buf bytes.Buffer
+	table.Write(&buf)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if got, want := displayWidth(line), 2*(minColumnWidth+3)+1; got != want {
+			t.Errorf("Line is %d columns wide, want %d: %s", got, want, line)
+		}
+	}
+}
+
+func TestTableWithManyColumns(t *testing.T) {
+	// 30 columns don't fit 80 terminal columns, each keeps the minimal width and wraps its content
+	withTerminalWidth(t, 80)
+	const columns = 30
+	headers := make([]string, columns)
+	row := make([]string, columns)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("column_%02d", i)
+		row[i] = fmt.Sprintf("value %d", i*1000)
+	}
+	table := NewTable(headers)
+	table.AddRow(row)
+	table.SetFooter(row)
+
+	var buf bytes.Buffer
+	table.Write(&buf)
+	for i, colWidth := range table.colWidths {
+		if colWidth < minColumnWidth {
+			t.Errorf("Column %d is %d wide, want at least %d", i, colWidth, minColumnWidth)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := columns*(minColumnWidth+3) + 1
+	for _, line := range lines {
+		if displayWidth(line) != want {
+			t.Errorf("Line is %d columns wide, want %d: %s", displayWidth(line), want, line)
+		}
+	}
+	if !strings.Contains(strings.ReplaceAll(buf.String(), " ", ""), "|col|") {
+		t.Errorf("Headers were not wrapped into their columns:\n%s", buf.String())
+	}
+}
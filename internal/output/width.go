@@ -0,0 +1,88 @@
+package output
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ranges of characters that take two terminal columns (CJK, Hangul, fullwidth forms and emoji)
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F680, 0x1F6FF},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x3FFFD},
+}
+
+func runeWidth(r rune) int {
+	if r == 0x200D || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r) ||
+		(r >= 0xFE00 && r <= 0xFE0F) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+func padRight(s string, width int) string {
+	if padding := width - displayWidth(s); padding > 0 {
+		return s + strings.Repeat(" ", padding)
+	}
+	return s
+}
+
+// wrapText breaks text into lines of at most width columns, after the last space of a line if
+// there is one and otherwise between runes. No characters are dropped, so unwrapText restores the text
+func wrapText(text string, width int) string {
+	if width < 1 || displayWidth(text) <= width {
+		return text
+	}
+
+	var lines []string
+	for displayWidth(text) > width {
+		cut, lastSpace, used := 0, 0, 0
+		for cut < len(text) {
+			r, size := utf8.DecodeRuneInString(text[cut:])
+			if used+runeWidth(r) > width {
+				break
+			}
+			used += runeWidth(r)
+			cut += size
+			if r == ' ' {
+				lastSpace = cut
+			}
+		}
+		if cut == 0 {
+			// A single rune wider than the column
+			_, cut = utf8.DecodeRuneInString(text)
+		} else if lastSpace > 0 {
+			cut = lastSpace
+		}
+		lines = append(lines, text[:cut])
+		text = text[cut:]
+	}
+	lines = append(lines, text)
+	return strings.Join(lines, "\n")
+}
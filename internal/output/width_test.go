@@ -0,0 +1,53 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWrapTextKeepsRunesWhole(t *testing.T) {
+	texts := []string{
+		"GET https://例え.jp/検索?q=東京タワー HTTP/2.0",
+		"Ünïcödé ümläüts äré twö bytés éäch",
+		"🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀",
+		"👩‍💻👩‍💻👩‍💻 zero width joiners",
+		"ééé combining accents éé",
+		"한국어 텍스트와 ascii text mixed",
+	}
+	for _, text := range texts {
+		// Every width puts the cut at a different byte of the multi-byte runes
+		for width := 1; width <= displayWidth(text)+1; width++ {
+			wrapped := wrapText(text, width)
+			if !utf8.ValidString(wrapped) {
+				t.Fatalf("wrapText(%q, %d) is not valid UTF-8: %q", text, width, wrapped)
+			}
+			for _, line := range strings.Split(wrapped, "\n") {
+				if !utf8.ValidString(line) {
+					t.Errorf("wrapText(%q, %d) has a line that is not valid UTF-8: %q", text, width, line)
+				}
+				// Only a single rune wider than the column may exceed it
+				if displayWidth(line) > width && utf8.RuneCountInString(line) > 1 {
+					t.Errorf("wrapText(%q, %d) has a line of %d columns: %q", text, width, displayWidth(line), line)
+				}
+			}
+			if unwrapText(wrapped) != text {
+				t.Errorf("unwrapText(wrapText(%q, %d)) = %q", text, width, unwrapText(wrapped))
+			}
+		}
+	}
+}
+
+func TestTableOutputIsValidUTF8(t *testing.T) {
+	withTerminalWidth(t, 40)
+	table := NewTable([]string{"país", "ユーザー", "request"})
+	table.AddRow([]string{"España", "日本語のユーザーエージェント", "GET /🚀/ümläüts/검색 HTTP/1.1"})
+	table.AddRow([]string{"Ελλάδα", "👩‍💻", strings.Repeat("é", 100)})
+
+	var buf bytes.Buffer
+	table.Write(&buf)
+	if !utf8.Valid(buf.Bytes()) {
+		t.Errorf("Table output is not valid UTF-8:\n%q", buf.String())
+	}
+}
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var Formats = []string{"table", "csv", "json", "markdown", "html"}
+
+type Options struct {
+	// Header is ignored by formats that cannot be read without it (json, markdown, html)
+	Header bool
+	// Null is the placeholder for NULL values, JSON always writes null
+	Null string
+	// FixedWidths keeps the even split of the terminal width between table columns
+	FixedWidths bool
+	// JSONLines writes JSON as one object per line instead of an array
+	JSONLines bool
+}
+
+// Writer renders query results in one output format. Flush must be called after the last row,
+// writers that need to see all rows before rendering (like the table) write everything there
+type Writer interface {
+	WriteHeader(columns []string) error
+	WriteRow(row []interface{}) error
+	Flush() error
+}
+
+// FooterWriter is implemented by writers that can show a totals row below the results
+type FooterWriter interface {
+	WriteFooter(footer []string) error
+}
+
+func NewWriter(format string, w io.Writer, opts Options) (Writer, error) {
+	switch format {
+	case "table":
+		return &tableWriter{w: w, opts: opts}, nil
+	case "csv":
+		return newCSVWriter(w, opts), nil
+	case "json":
+		return &jsonWriter{w: w, lines: opts.JSONLines}, nil
+	case "markdown":
+		return &markdownWriter{w: w, opts: opts}, nil
+	case "html":
+		return &htmlWriter{w: w, opts: opts}, nil
+	}
+	return nil, fmt.Errorf("Unknown output format '%s'. Use %s", format, formatList())
+}
+
+func formatList() string {
+	quoted := make([]string, len(Formats))
+	for i, format := range Formats {
+		quoted[i] = fmt.Sprintf("'%s'", format)
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + ", or " + quoted[len(quoted)-1]
+}
+
+func formatValues(row []interface{}, null string) []string {
+	formatted := make([]string, len(row))
+	for i, value := range row {
+		if value == nil {
+			formatted[i] = null
+		} else {
+			formatted[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	return formatted
+}
+
+type tableWriter struct {
+	w     io.Writer
+	opts  Options
+	table *Table
+}
+
+func (t *tableWriter) WriteHeader(columns []string) error {
+	t.table = NewTable(columns)
+	if !t.opts.Header {
+		t.table.HideHeader()
+	}
+	if t.opts.FixedWidths {
+		t.table.FixWidths()
+	}
+	return nil
+}
+
+func (t *tableWriter) WriteRow(row []interface{}) error {
+	t.table.AddRow(formatValues(row, t.opts.Null))
+	return nil
+}
+
+func (t *tableWriter) WriteFooter(footer []string) error {
+	t.table.SetFooter(footer)
+	return nil
+}
+
+func (t *tableWriter) Flush() error {
+	t.table.Write(t.w)
+	return nil
+}
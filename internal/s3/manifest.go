@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/frederikmartin/logwarts/internal/logger"
+)
+
+// manifest is the manifest.json of an S3 Inventory report, the objects are listed in the referenced files
+type manifest struct {
+	SourceBucket      string         `json:"sourceBucket"`
+	DestinationBucket string         `json:"destinationBucket"`
+	FileFormat        string         `json:"fileFormat"`
+	FileSchema        string         `json:"fileSchema"`
+	Files             []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// parseS3URL splits an s3://bucket/key URL
+func parseS3URL(rawURL string) (string, string, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(rawURL, "s3://"), "/")
+	if !strings.HasPrefix(rawURL, "s3://") || !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("Invalid S3 URL '%s', expected s3://bucket/key", rawURL)
+	}
+	return bucket, key, nil
+}
+
+func (m *manifest) validate() error {
+	if m.SourceBucket == "" || m.DestinationBucket == "" {
+		return fmt.Errorf("sourceBucket and destinationBucket are required")
+	}
+	// ORC and Parquet inventories would need their own readers
+	if m.FileFormat != "CSV" {
+		return fmt.Errorf("unsupported fileFormat '%s', only CSV inventories are supported", m.FileFormat)
+	}
+	if !slices.Contains(m.schema(), "Key") {
+		return fmt.Errorf("fileSchema '%s' has no Key field", m.FileSchema)
+	}
+	if len(m.Files) == 0 {
+		return fmt.Errorf("no inventory files listed")
+	}
+	for _, file := range m.Files {
+		if file.Key == "" {
+			return fmt.Errorf("inventory file without key")
+		}
+	}
+	return nil
+}
+
+func (m *manifest) schema() []string {
+	fields := strings.Split(m.FileSchema, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+// listManifest returns the objects of bucket that the inventory report lists under any of the prefixes and in the
+// time range, without listing the bucket itself. The inventory files are streamed rather than loaded
+func (s *S3Client) listManifest(bucket string, prefixes []string, timeRange TimeRange) ([]types.Object, error) {
+	ctx := context.TODO()
+	manifestBucket, manifestKey, err := parseS3URL(s.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(manifestBucket), Key: aws.String(manifestKey)})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download manifest '%s': %v", s.Manifest, err)
+	}
+	var inventory manifest
+	err = json.NewDecoder(output.Body).Decode(&inventory)
+	output.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest '%s': %v", s.Manifest, err)
+	}
+	if err := inventory.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid manifest '%s': %v", s.Manifest, err)
+	}
+	if inventory.SourceBucket != bucket {
+		return nil, fmt.Errorf("Manifest '%s' lists objects of bucket '%s', not '%s'", s.Manifest, inventory.SourceBucket, bucket)
+	}
+
+	// The destination is given as an ARN like arn:aws:s3:::bucket
+	inventoryBucket := strings.TrimPrefix(inventory.DestinationBucket, "arn:aws:s3:::")
+	var objects []types.Object
+	for _, file := range inventory.Files {
+		listed, err := s.readInventoryFile(ctx, inventoryBucket, file.Key, inventory.schema(), func(object types.Object) bool {
+			key := aws.ToString(object.Key)
+			if !slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) }) {
+				return false
+			}
+			return object.LastModified == nil || timeRange.Contains(*object.LastModified)
+		})
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, listed...)
+	}
+
+	logger.Debugf("Manifest %s lists %d object(s) in s3://%s/%s", s.Manifest, len(objects), bucket, describePrefixes(prefixes))
+	return objects, nil
+}
+
+func (s *S3Client) readInventoryFile(ctx context.Context, bucket, key string, schema []string, keep func(types.Object) bool) ([]types.Object, error) {
+	logger.Debugf("Reading inventory file s3://%s/%s", bucket, key)
+	output, err := s.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download inventory file '%s': %v", key, err)
+	}
+	defer output.Body.Close()
+
+	// CSV inventory files are always gzipped
+	gz, err := gzip.NewReader(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress inventory file '%s': %v", key, err)
+	}
+	defer gz.Close()
+
+	reader := csv.NewReader(gz)
+	reader.FieldsPerRecord = len(schema)
+	var objects []types.Object
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read inventory file '%s': %v", key, err)
+		}
+		object, err := inventoryObject(schema, record)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid record in inventory file '%s': %v", key, err)
+		}
+		if keep(object) {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+func inventoryObject(schema, record []string) (types.Object, error) {
+	var object types.Object
+	for i, field := range schema {
+		value := record[i]
+		switch field {
+		case "Key":
+			// Keys are URL-encoded in CSV inventories
+			key, err := url.QueryUnescape(value)
+			if err != nil {
+				return object, fmt.Errorf("invalid key '%s': %v", value, err)
+			}
+			object.Key = aws.String(key)
+		case "Size":
+			if value == "" {
+				continue
+			}
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return object, fmt.Errorf("invalid size '%s': %v", value, err)
+			}
+			object.Size = aws.Int64(size)
+		case "LastModifiedDate":
+			if value == "" {
+				continue
+			}
+			modified, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return object, fmt.Errorf("invalid last modified date '%s': %v", value, err)
+			}
+			object.LastModified = aws.Time(modified)
+		case "ETag":
+			object.ETag = aws.String(value)
+		}
+	}
+	return object, nil
+}
@@ -0,0 +1,67 @@
+package s3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// prefixTokens are the placeholders a prefix template may contain, with the date layout each one expands to
+var prefixTokens = map[string]string{
+	"{yyyy}": "2006",
+	"{MM}":   "01",
+	"{dd}":   "02",
+}
+
+var prefixTokenPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// ExpandPrefixTemplate returns the template with its date tokens replaced for every UTC day of the time range,
+// in order and without duplicates. The range must have a start, an open end means until now
+func ExpandPrefixTemplate(template string, timeRange TimeRange) ([]string, error) {
+	tokens := prefixTokenPattern.FindAllString(template, -1)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Prefix template '%s' contains no date tokens, use {yyyy}, {MM} and {dd}", template)
+	}
+	for _, token := range tokens {
+		if _, ok := prefixTokens[token]; !ok {
+			return nil, fmt.Errorf("Unknown token %s in prefix template '%s', use {yyyy}, {MM} and {dd}", token, template)
+		}
+	}
+	if timeRange.Since.IsZero() {
+		return nil, fmt.Errorf("A prefix template requires a start time")
+	}
+
+	until := timeRange.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	// Until is exclusive, so a range ending at midnight doesn't include the next day
+	last := until.UTC().Add(-time.Nanosecond)
+	day := truncateDay(timeRange.Since.UTC())
+	var prefixes []string
+	for !day.After(last) {
+		prefix := prefixTokenPattern.ReplaceAllStringFunc(template, func(token string) string {
+			return day.Format(prefixTokens[token])
+		})
+		if len(prefixes) == 0 || prefixes[len(prefixes)-1] != prefix {
+			prefixes = append(prefixes, prefix)
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("The time range from %s to %s is empty", timeRange.Since.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	return prefixes, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func describePrefixes(prefixes []string) string {
+	if len(prefixes) == 1 {
+		return prefixes[0]
+	}
+	return fmt.Sprintf("{%s}", strings.Join(prefixes, ","))
+}
@@ -1,88 +1,472 @@
 package s3
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/frederikmartin/logwarts/internal/logger"
 )
 
+const downloadAttempts = 3
+
+// API is the part of the S3 API logwarts calls, *s3.Client implements it
+type API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// LogSource lists and downloads log objects, S3Client implements it against an S3 bucket
+type LogSource interface {
+	ListLogs(bucket string, prefixes []string, timeRange TimeRange) ([]types.Object, error)
+	DownloadLogs(ctx context.Context, bucket string, prefixes []string, downloadDir string, timeRange TimeRange, skip func(types.Object) bool, progressCallback func(current, total int)) (*DownloadResult, error)
+}
+
+var _ LogSource = (*S3Client)(nil)
+
 type S3Client struct {
-	Client *s3.Client
+	Client API
+	Verify bool
+	// Concurrency is the number of objects downloaded at the same time
+	Concurrency int
+	// RateLimit caps the GetObject requests per second across all downloads, 0 doesn't limit them
+	RateLimit float64
+	// Decompress stores gzipped objects decompressed instead of as they are in the bucket
+	Decompress bool
+	// Manifest is the s3:// URL of an S3 Inventory manifest.json, if set objects are taken from
+	// the inventory instead of listing the bucket
+	Manifest string
+}
+
+// Rate limits outside this range would space requests by less than the ticker's resolution
+// or by more than a time.Duration can hold
+const (
+	MinRateLimit = 0.001
+	MaxRateLimit = 10000
+)
+
+// ValidateRateLimit accepts 0 for no limit or a rate of requests per second between MinRateLimit and MaxRateLimit
+func ValidateRateLimit(perSecond float64) error {
+	if perSecond == 0 {
+		return nil
+	}
+	if !(perSecond >= MinRateLimit && perSecond <= MaxRateLimit) {
+		return fmt.Errorf("Rate limit must be 0 for no limit or between %g and %g requests per second, got %g", float64(MinRateLimit), float64(MaxRateLimit), perSecond)
+	}
+	return nil
+}
+
+// limiter spaces requests evenly to stay below a rate, a limiter for rate 0 doesn't wait
+type limiter struct {
+	ticker *time.Ticker
+}
+
+func newLimiter(perSecond float64) *limiter {
+	if perSecond <= 0 {
+		return &limiter{}
+	}
+	return &limiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	if l.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
 }
 
-func NewS3Client() (*S3Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (r TimeRange) Contains(t time.Time) bool {
+	if !r.Since.IsZero() && t.Before(r.Since) {
+		return false
+	}
+	if !r.Until.IsZero() && !t.Before(r.Until) {
+		return false
+	}
+	return true
+}
+
+// ClientOptions override parts of the default AWS config chain, zero values keep the defaults
+type ClientOptions struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	CredentialsFile string
+	AssumeRoleARN   string
+	ExternalID      string
+	Region          string
+}
+
+func (o ClientOptions) loadOptions() ([]func(*config.LoadOptions) error, error) {
+	var opts []func(*config.LoadOptions) error
+	if o.Region != "" {
+		opts = append(opts, config.WithRegion(o.Region))
+	}
+	if o.AccessKeyID != "" || o.SecretAccessKey != "" {
+		if o.AccessKeyID == "" || o.SecretAccessKey == "" {
+			return nil, fmt.Errorf("Both an AWS access key id and a secret access key are required")
+		}
+		if o.CredentialsFile != "" {
+			return nil, fmt.Errorf("AWS access keys and a credentials file cannot be used together")
+		}
+		provider := credentials.NewStaticCredentialsProvider(o.AccessKeyID, o.SecretAccessKey, o.SessionToken)
+		opts = append(opts, config.WithCredentialsProvider(provider))
+	} else if o.SessionToken != "" {
+		return nil, fmt.Errorf("An AWS session token requires an access key id and a secret access key")
+	}
+	if o.CredentialsFile != "" {
+		if _, err := os.Stat(o.CredentialsFile); err != nil {
+			return nil, fmt.Errorf("Unable to read AWS credentials file: %v", err)
+		}
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{o.CredentialsFile}))
+	}
+	return opts, nil
+}
+
+func NewS3Client(options ClientOptions) (*S3Client, error) {
+	logger.Debugf("S3 client: region=%q access key id=%s secret access key=%s session token=%s credentials file=%q role=%q external id=%s",
+		options.Region, logger.Redact(options.AccessKeyID), logger.Redact(options.SecretAccessKey), logger.Redact(options.SessionToken),
+		options.CredentialsFile, options.AssumeRoleARN, logger.Redact(options.ExternalID))
+	opts, err := options.loadOptions()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to load AWS SDK config: %v", err)
 	}
 
+	// The base credentials are only used to call STS, S3 requests are signed with the assumed role
+	if options.AssumeRoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), options.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "logwarts"
+			if options.ExternalID != "" {
+				o.ExternalID = aws.String(options.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	} else if options.ExternalID != "" {
+		return nil, fmt.Errorf("An external id requires a role to assume")
+	}
+
 	client := s3.NewFromConfig(cfg)
 	return &S3Client{Client: client}, nil
 }
 
-func (s *S3Client) ListLogs(bucket, prefix string) ([]types.Object, error) {
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
+// ListLogs returns the objects under any of the prefixes that were last modified in the time range
+func (s *S3Client) ListLogs(bucket string, prefixes []string, timeRange TimeRange) ([]types.Object, error) {
+	if s.Manifest != "" {
+		return s.listManifest(bucket, prefixes, timeRange)
 	}
 
-	output, err := s.Client.ListObjectsV2(context.TODO(), input)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to list objects in bucket '%s': %v", bucket, err)
+	var objects []types.Object
+	for _, prefix := range prefixes {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		}
+
+		paginator := s3.NewListObjectsV2Paginator(s.Client, input)
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return nil, fmt.Errorf("Failed to list objects in bucket '%s': %v", bucket, err)
+			}
+			for _, object := range output.Contents {
+				if object.LastModified != nil && !timeRange.Contains(*object.LastModified) {
+					continue
+				}
+				// Folder markers created by the console aren't logs
+				if strings.HasSuffix(aws.ToString(object.Key), "/") {
+					continue
+				}
+				objects = append(objects, object)
+			}
+		}
 	}
 
-	return output.Contents, nil
+	logger.Debugf("Listed %d object(s) in s3://%s/%s", len(objects), bucket, describePrefixes(prefixes))
+	return objects, nil
 }
 
-func (s *S3Client) DownloadLog(bucket, key, downloadDir string) error {
+// downloadName returns the name of the file an object is stored in directly inside the download directory.
+// Objects under a prefix get a short hash of the prefix in front, so that objects with the same name under
+// different prefixes don't overwrite each other and keys like ../x or /x can't leave the download directory
+func downloadName(key string) (string, error) {
+	slash := strings.LastIndex(key, "/")
+	base := key[slash+1:]
+	if base == "" || base == "." || base == ".." {
+		return "", fmt.Errorf("Object key '%s' has no file name", key)
+	}
+	// Backslashes separate directories on Windows
+	base = strings.ReplaceAll(base, `\`, "_")
+	if slash >= 0 {
+		sum := sha256.Sum256([]byte(key[:slash+1]))
+		base = hex.EncodeToString(sum[:4]) + "_" + base
+	}
+	return base, nil
+}
+
+// DownloadLog stores the object in downloadDir and returns the path of the file. Whether the object is gzipped
+// is taken from its content, its Content-Encoding and its key, in that order, and the file name ends in .gz
+// if and only if the stored content is gzipped
+func (s *S3Client) DownloadLog(ctx context.Context, bucket, key, downloadDir string) (string, error) {
+	name, err := downloadName(key)
+	if err != nil {
+		return "", err
+	}
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	}
 
-	output, err := s.Client.GetObject(context.TODO(), input)
+	logger.Debugf("Downloading s3://%s/%s", bucket, key)
+	start := time.Now()
+	output, err := s.Client.GetObject(ctx, input)
 	if err != nil {
-		return fmt.Errorf("Failed to download object '%s': %v", key, err)
+		return "", fmt.Errorf("Failed to download object '%s': %v", key, err)
 	}
 	defer output.Body.Close()
 
-	filePath := filepath.Join(downloadDir, filepath.Base(key))
+	// The checksum and size are those of the object as stored, so they are taken before decompressing
+	hash := md5.New()
+	counter := &countingWriter{}
+	body := bufio.NewReader(io.TeeReader(output.Body, io.MultiWriter(hash, counter)))
+	gzipped := isGzipped(body, aws.ToString(output.ContentEncoding), key)
+
+	var content io.Reader = body
+	name = strings.TrimSuffix(name, ".gz")
+	if gzipped && s.Decompress {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return "", fmt.Errorf("Failed to decompress object '%s': %v", key, err)
+		}
+		defer gz.Close()
+		content = gz
+	} else if gzipped {
+		name += ".gz"
+	}
+
+	filePath := filepath.Join(downloadDir, name)
 	file, err := os.Create(filePath)
 	if err != nil {
-		return fmt.Errorf("Failed to create file '%s': %v", filePath, err)
+		return "", fmt.Errorf("Failed to create file '%s': %v", filePath, err)
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, output.Body)
-	if err != nil {
-		return fmt.Errorf("Failed to copy content to file '%s': %v", filePath, err)
+	if _, err := io.Copy(file, content); err != nil {
+		return "", fmt.Errorf("Failed to copy content to file '%s': %v", filePath, err)
 	}
 
-	fmt.Printf("Downloaded '%s' to '%s'\n", key, filePath)
+	if s.Verify {
+		err = verifyDownload(output, counter.n, hash.Sum(nil))
+		if err != nil {
+			file.Close()
+			os.Remove(filePath)
+			return "", fmt.Errorf("Failed to verify object '%s': %v", key, err)
+		}
+	}
+
+	logger.Infof("Downloaded '%s' to '%s'\n", key, filePath)
+	logger.Debugf("Downloaded %d byte(s) of s3://%s/%s in %s (gzipped: %t)", counter.n, bucket, key, time.Since(start).Round(time.Millisecond), gzipped)
+	return filePath, nil
+}
+
+func isGzipped(body *bufio.Reader, contentEncoding, key string) bool {
+	if magic, err := body.Peek(2); err == nil {
+		return magic[0] == 0x1f && magic[1] == 0x8b
+	}
+	// Too short to tell by its content
+	if strings.Contains(strings.ToLower(contentEncoding), "gzip") {
+		return true
+	}
+	return strings.HasSuffix(key, ".gz")
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func verifyDownload(output *s3.GetObjectOutput, written int64, sum []byte) error {
+	if output.ContentLength != nil && *output.ContentLength != written {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", *output.ContentLength, written)
+	}
+
+	// Multipart uploads have an ETag of the form "<md5 of part md5s>-<parts>" which is no content MD5
+	etag := strings.Trim(aws.ToString(output.ETag), `"`)
+	if etag == "" || strings.Contains(etag, "-") {
+		return nil
+	}
+	if checksum := hex.EncodeToString(sum); checksum != etag {
+		return fmt.Errorf("checksum mismatch: ETag is %s, content MD5 is %s", etag, checksum)
+	}
 	return nil
 }
 
-func (s *S3Client) DownloadLogs(bucket, prefix, downloadDir string) error {
-	logFiles, err := s.ListLogs(bucket, prefix)
+type DownloadedObject struct {
+	Key          string
+	ETag         string
+	Path         string
+	Size         int64
+	LastModified time.Time
+}
+
+type DownloadError struct {
+	Key string
+	Err error
+}
+
+// DownloadResult lists what happened to every object DownloadLogs listed
+type DownloadResult struct {
+	// Downloaded is in listing order
+	Downloaded []DownloadedObject
+	// Skipped lists the keys of objects for which skip returned true
+	Skipped []string
+	Failed  []DownloadError
+	// Listed counts all objects under the prefixes in the time range
+	Listed int
+	// Interrupted is set if ctx was done before every object was downloaded
+	Interrupted bool
+}
+
+// Bytes sums the size of the downloaded objects as stored in S3
+func (r *DownloadResult) Bytes() int64 {
+	var bytes int64
+	for _, object := range r.Downloaded {
+		bytes += object.Size
+	}
+	return bytes
+}
+
+// DownloadLogs downloads all objects under the prefixes in the time range for which skip returns false,
+// it stops early once ctx is done. Only failing to list the objects is an error, failed downloads are
+// reported in the result
+func (s *S3Client) DownloadLogs(ctx context.Context, bucket string, prefixes []string, downloadDir string, timeRange TimeRange, skip func(types.Object) bool, progressCallback func(current, total int)) (*DownloadResult, error) {
+	listed, err := s.ListLogs(bucket, prefixes, timeRange)
 	if err != nil {
-		return fmt.Errorf("Failed to list log files: %v", err)
+		return nil, fmt.Errorf("Failed to list log files: %v", err)
 	}
 
-	for _, logFile := range logFiles {
-		err := s.DownloadLog(bucket, *logFile.Key, downloadDir)
-		if err != nil {
-			fmt.Printf("Failed to download log file '%s': %v\n", *logFile.Key, err)
-			continue
+	result := &DownloadResult{Listed: len(listed)}
+	var logFiles []types.Object
+	for _, object := range listed {
+		if skip == nil || !skip(object) {
+			logFiles = append(logFiles, object)
+		} else {
+			logger.Debugf("Skipping already imported s3://%s/%s", bucket, aws.ToString(object.Key))
+			result.Skipped = append(result.Skipped, aws.ToString(object.Key))
 		}
 	}
 
-	fmt.Printf("Downloaded %d log files to '%s'\n", len(logFiles), downloadDir)
-	return nil
+	limit := newLimiter(s.RateLimit)
+	defer limit.stop()
+
+	// Objects are downloaded concurrently but reported in listing order
+	results := make([]*DownloadedObject, len(logFiles))
+	errs := make([]error, len(logFiles))
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	total := len(logFiles)
+	for w := 0; w < max(s.Concurrency, 1) && w < total; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				logFile := logFiles[i]
+				var path string
+				var err error
+				for attempt := 1; attempt <= downloadAttempts; attempt++ {
+					// Retries wait for the rate limit like first attempts
+					if err = limit.wait(ctx); err != nil {
+						break
+					}
+					path, err = s.DownloadLog(ctx, bucket, *logFile.Key, downloadDir)
+					if err == nil {
+						break
+					}
+					logger.Debugf("Attempt %d of %d to download '%s' failed: %v", attempt, downloadAttempts, *logFile.Key, err)
+				}
+
+				mu.Lock()
+				if err != nil {
+					// Downloads cancelled by an interruption aren't failures
+					if ctx.Err() == nil {
+						errs[i] = err
+					}
+				} else {
+					results[i] = &DownloadedObject{
+						Key:          *logFile.Key,
+						ETag:         strings.Trim(aws.ToString(logFile.ETag), `"`),
+						Path:         path,
+						Size:         aws.ToInt64(logFile.Size),
+						LastModified: aws.ToTime(logFile.LastModified),
+					}
+				}
+				done++
+				if progressCallback != nil {
+					progressCallback(done, total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for i := range logFiles {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, downloaded := range results {
+		if downloaded != nil {
+			result.Downloaded = append(result.Downloaded, *downloaded)
+		} else if errs[i] != nil {
+			result.Failed = append(result.Failed, DownloadError{Key: *logFiles[i].Key, Err: errs[i]})
+		}
+	}
+	result.Interrupted = ctx.Err() != nil
+	return result, nil
 }
@@ -0,0 +1,336 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeAPI serves objects from memory, listing them in pages of pageSize. GetObject fails
+// the first failures[key] calls for a key, which makes a key that fails more often than
+// downloadAttempts fail for good. contentLengths overrides the length GetObject reports
+type fakeAPI struct {
+	objects        []types.Object
+	bodies         map[string]string
+	pageSize       int
+	failures       map[string]int
+	contentLengths map[string]int64
+
+	mu       sync.Mutex
+	listings int
+	gets     map[string]int
+}
+
+func newFakeAPI(pageSize int) *fakeAPI {
+	return &fakeAPI{bodies: make(map[string]string), pageSize: pageSize, failures: make(map[string]int), contentLengths: make(map[string]int64), gets: make(map[string]int)}
+}
+
+func (f *fakeAPI) add(key, body string, lastModified time.Time) {
+	f.objects = append(f.objects, types.Object{
+		Key:          aws.String(key),
+		ETag:         aws.String(fmt.Sprintf(`"etag-%s"`, key)),
+		Size:         aws.Int64(int64(len(body))),
+		LastModified: aws.Time(lastModified),
+	})
+	f.bodies[key] = body
+}
+
+func (f *fakeAPI) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	f.listings++
+	f.mu.Unlock()
+
+	var matching []types.Object
+	for _, object := range f.objects {
+		if strings.HasPrefix(aws.ToString(object.Key), aws.ToString(params.Prefix)) {
+			matching = append(matching, object)
+		}
+	}
+	start := 0
+	if params.ContinuationToken != nil {
+		var err error
+		start, err = strconv.Atoi(*params.ContinuationToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid continuation token %q", *params.ContinuationToken)
+		}
+	}
+	end := min(start+f.pageSize, len(matching))
+	output := &s3.ListObjectsV2Output{Contents: matching[start:end], IsTruncated: aws.Bool(end < len(matching))}
+	if end < len(matching) {
+		output.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func (f *fakeAPI) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	key := aws.ToString(params.Key)
+	f.mu.Lock()
+	f.gets[key]++
+	calls := f.gets[key]
+	f.mu.Unlock()
+
+	if calls <= f.failures[key] {
+		return nil, fmt.Errorf("injected failure %d for '%s'", calls, key)
+	}
+	body, ok := f.bodies[key]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", key)
+	}
+	length, ok := f.contentLengths[key]
+	if !ok {
+		length = int64(len(body))
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: aws.Int64(length),
+	}, nil
+}
+
+func (f *fakeAPI) getCalls(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gets[key]
+}
+
+func TestListLogsFollowsPages(t *testing.T) {
+	api := newFakeAPI(2)
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		api.add(fmt.Sprintf("logs/%d.log", i), "line\n", start.Add(time.Duration(i)*time.Hour))
+	}
+	api.add("other/0.log", "line\n", start)
+	client := &S3Client{Client: api}
+
+	objects, err := client.ListLogs("bucket", []string{"logs/"}, TimeRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 5 {
+		t.Errorf("Listed %d object(s) across pages, want 5", len(objects))
+	}
+	if api.listings != 3 {
+		t.Errorf("Made %d ListObjectsV2 call(s) for 5 objects in pages of 2, want 3", api.listings)
+	}
+
+	objects, err = client.ListLogs("bucket", []string{"logs/"}, TimeRange{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || aws.ToString(objects[0].Key) != "logs/1.log" || aws.ToString(objects[1].Key) != "logs/2.log" {
+		t.Errorf("Listed %d object(s) in the time range, want logs/1.log and logs/2.log", len(objects))
+	}
+}
+
+func TestDownloadLogsReportsSkippedRetriedAndFailedObjects(t *testing.T) {
+	api := newFakeAPI(2)
+	modified := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, key := range []string{"logs/a.log", "logs/b.log", "logs/flaky.log", "logs/broken.log", "logs/imported.log"} {
+		api.add(key, "content of "+key+"\n", modified)
+	}
+	api.failures["logs/flaky.log"] = downloadAttempts - 1
+	api.failures["logs/broken.log"] = downloadAttempts
+	client := &S3Client{Client: api, Concurrency: 3}
+
+	skip := func(object types.Object) bool {
+		return aws.ToString(object.Key) == "logs/imported.log"
+	}
+	var mu sync.Mutex
+	lastProgress, progressTotal := 0, 0
+	result, err := client.DownloadLogs(context.Background(), "bucket", []string{"logs/"}, t.TempDir(), TimeRange{}, skip, func(current, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		lastProgress, progressTotal = current, total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Listed != 5 {
+		t.Errorf("Listed = %d, want 5", result.Listed)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0] != "logs/imported.log" {
+		t.Errorf("Skipped = %v, want [logs/imported.log]", result.Skipped)
+	}
+	var downloaded []string
+	for _, object := range result.Downloaded {
+		downloaded = append(downloaded, object.Key)
+	}
+	if want := "logs/a.log logs/b.log logs/flaky.log"; strings.Join(downloaded, " ") != want {
+		t.Errorf("Downloaded %v, want %s in listing order", downloaded, want)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Key != "logs/broken.log" || result.Failed[0].Err == nil {
+		t.Errorf("Failed = %v, want logs/broken.log with its error", result.Failed)
+	}
+	if result.Interrupted {
+		t.Error("Interrupted is set for a download that ran to the end")
+	}
+	var want int64
+	for _, key := range downloaded {
+		want += int64(len(api.bodies[key]))
+	}
+	if result.Bytes() != want {
+		t.Errorf("Bytes() = %d, want %d", result.Bytes(), want)
+	}
+
+	if calls := api.getCalls("logs/flaky.log"); calls != downloadAttempts {
+		t.Errorf("GetObject was called %d time(s) for a key that succeeds on the last retry, want %d", calls, downloadAttempts)
+	}
+	if calls := api.getCalls("logs/broken.log"); calls != downloadAttempts {
+		t.Errorf("GetObject was called %d time(s) for a key that always fails, want %d", calls, downloadAttempts)
+	}
+	if calls := api.getCalls("logs/imported.log"); calls != 0 {
+		t.Errorf("GetObject was called %d time(s) for a skipped key, want 0", calls)
+	}
+	if lastProgress != 4 || progressTotal != 4 {
+		t.Errorf("Last progress was %d/%d, want 4/4", lastProgress, progressTotal)
+	}
+}
+
+func TestDownloadLogsStopsWhenInterrupted(t *testing.T) {
+	api := newFakeAPI(10)
+	for i := 0; i < 3; i++ {
+		api.add(fmt.Sprintf("logs/%d.log", i), "line\n", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	}
+	client := &S3Client{Client: api, Concurrency: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := client.DownloadLogs(ctx, "bucket", []string{"logs/"}, t.TempDir(), TimeRange{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Interrupted {
+		t.Error("Interrupted is not set after the context was cancelled")
+	}
+	if len(result.Failed) != 0 {
+		t.Errorf("Failed = %v, cancelled downloads must not count as failures", result.Failed)
+	}
+}
+
+func TestVerifyDownload(t *testing.T) {
+	body := []byte("http 2024-01-02T00:00:00.000000Z app/test\n")
+	sum := md5.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	tests := []struct {
+		name    string
+		output  *s3.GetObjectOutput
+		written int64
+		wantErr string
+	}{
+		{"matching size and ETag", &s3.GetObjectOutput{ContentLength: aws.Int64(int64(len(body))), ETag: aws.String(etag)}, int64(len(body)), ""},
+		{"body shorter than announced", &s3.GetObjectOutput{ContentLength: aws.Int64(int64(len(body)) + 10), ETag: aws.String(etag)}, int64(len(body)), "size mismatch"},
+		{"body longer than announced", &s3.GetObjectOutput{ContentLength: aws.Int64(int64(len(body)) - 1), ETag: aws.String(etag)}, int64(len(body)), "size mismatch"},
+		{"empty body", &s3.GetObjectOutput{ContentLength: aws.Int64(int64(len(body)))}, 0, "size mismatch"},
+		{"different content", &s3.GetObjectOutput{ContentLength: aws.Int64(int64(len(body))), ETag: aws.String(`"00000000000000000000000000000000"`)}, int64(len(body)), "checksum mismatch"},
+		{"multipart ETag", &s3.GetObjectOutput{ContentLength: aws.Int64(int64(len(body))), ETag: aws.String(`"9b2cf535f27731c974343645a3985328-3"`)}, int64(len(body)), ""},
+		{"no size or ETag", &s3.GetObjectOutput{}, int64(len(body)), ""},
+	}
+	for _, tt := range tests {
+		err := verifyDownload(tt.output, tt.written, sum[:])
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: verifyDownload = %v, want nil", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: verifyDownload = %v, want a %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDownloadLogsRejectsTruncatedBodies(t *testing.T) {
+	api := newFakeAPI(10)
+	modified := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	api.add("logs/complete.log", "line\n", modified)
+	api.add("logs/truncated.log", "line\n", modified)
+	api.contentLengths["logs/truncated.log"] = 100
+	dir := t.TempDir()
+	client := &S3Client{Client: api, Concurrency: 1, Verify: true}
+
+	result, err := client.DownloadLogs(context.Background(), "bucket", []string{"logs/"}, dir, TimeRange{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Downloaded) != 1 || result.Downloaded[0].Key != "logs/complete.log" {
+		t.Errorf("Downloaded %v, want only logs/complete.log", result.Downloaded)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Key != "logs/truncated.log" {
+		t.Errorf("Failed = %v, want logs/truncated.log", result.Failed)
+	}
+	if files, err := os.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Errorf("Download directory has %d file(s), %v, want only the complete download", len(files), err)
+	}
+}
+
+func TestDownloadLogsKeepsFilesInsideTheDownloadDirectory(t *testing.T) {
+	api := newFakeAPI(10)
+	modified := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	keys := []string{"x.log", "prod/x.log", "staging/x.log", "../x.log", "/abs/x.log", "a/../../x.log", `..\..\x.log`, "logs/"}
+	for _, key := range keys {
+		api.add(key, "content of "+key+"\n", modified)
+	}
+	dir := t.TempDir()
+	client := &S3Client{Client: api, Concurrency: 4}
+
+	result, err := client.DownloadLogs(context.Background(), "bucket", []string{""}, dir, TimeRange{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Listed != len(keys)-1 || len(result.Failed) != 0 {
+		t.Errorf("Listed %d object(s) with failures %v, want %d without the folder marker and no failures", result.Listed, result.Failed, len(keys)-1)
+	}
+	paths := make(map[string]string)
+	for _, object := range result.Downloaded {
+		if filepath.Dir(object.Path) != dir {
+			t.Errorf("'%s' was stored in '%s', outside of the download directory", object.Key, object.Path)
+		}
+		if other, ok := paths[object.Path]; ok {
+			t.Errorf("'%s' and '%s' were both stored in '%s'", other, object.Key, object.Path)
+		}
+		paths[object.Path] = object.Key
+		content, err := os.ReadFile(object.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != api.bodies[object.Key] {
+			t.Errorf("'%s' contains %q, want the content of '%s'", object.Path, content, object.Key)
+		}
+	}
+	if len(result.Downloaded) != len(keys)-1 {
+		t.Errorf("Downloaded %d object(s), want %d", len(result.Downloaded), len(keys)-1)
+	}
+
+	for _, key := range []string{"", "logs/", "..", "/"} {
+		if _, err := client.DownloadLog(context.Background(), "bucket", key, dir); err == nil {
+			t.Errorf("DownloadLog(%q) succeeded for a key without a file name", key)
+		}
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	for _, perSecond := range []float64{0, MinRateLimit, 1, 10, MaxRateLimit} {
+		if err := ValidateRateLimit(perSecond); err != nil {
+			t.Errorf("ValidateRateLimit(%g) = %v, want nil", perSecond, err)
+		}
+		// Every valid rate must make a ticker, which panics for intervals <= 0
+		newLimiter(perSecond).stop()
+	}
+	for _, perSecond := range []float64{-1, 1e-12, MaxRateLimit + 1, 2e9, math.Inf(1), math.NaN()} {
+		if err := ValidateRateLimit(perSecond); err == nil {
+			t.Errorf("ValidateRateLimit(%g) = nil, want an error", perSecond)
+		}
+	}
+}
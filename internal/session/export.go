@@ -0,0 +1,99 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+type exportedSession struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	DBPath string `json:"db_path"`
+}
+
+// ExportSessions writes the session metadata as JSON, the log databases are not included
+func ExportSessions(w io.Writer) error {
+	sessions, err := ListSessions()
+	if err != nil {
+		return err
+	}
+
+	exported := make([]exportedSession, len(sessions))
+	for i, session := range sessions {
+		exported[i] = exportedSession{Name: session.Name, State: session.State, DBPath: session.DBPath}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(exported); err != nil {
+		return fmt.Errorf("Failed to write sessions: %v", err)
+	}
+	return nil
+}
+
+// ImportSessions adds the exported sessions as inactive sessions, exact duplicates are skipped. Nothing is
+// imported if a name is invalid or already taken by a session with another db path
+func ImportSessions(r io.Reader) ([]Session, error) {
+	var exported []exportedSession
+	if err := json.NewDecoder(r).Decode(&exported); err != nil {
+		return nil, fmt.Errorf("Failed to read sessions: %v", err)
+	}
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if sessionDB == nil {
+		return nil, fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
+	}
+
+	tx, err := sessionDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var imported []Session
+	for _, e := range exported {
+		// The log table in the db file is named after the session, so the name can't change on import
+		name, err := SanitizeSessionName(e.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid session name '%s': %v", e.Name, err)
+		}
+		if name != e.Name {
+			return nil, fmt.Errorf("Invalid session name '%s': its log table would be named after '%s'", e.Name, name)
+		}
+		if e.DBPath == "" {
+			return nil, fmt.Errorf("Session '%s' has no db path", e.Name)
+		}
+		dbPath, err := filepath.Abs(e.DBPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve db path of session '%s': %v", e.Name, err)
+		}
+
+		var existingPath string
+		err = tx.QueryRow(`SELECT db_path FROM sessions WHERE name = ?`, name).Scan(&existingPath)
+		if err == nil {
+			if existingPath == dbPath {
+				continue
+			}
+			return nil, fmt.Errorf("Session '%s' already exists with db path '%s', kill it before importing the one at '%s'", name, existingPath, dbPath)
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("Failed to look up session '%s': %v", name, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO sessions (name, state, db_path) VALUES (?, 'inactive', ?)`, name, dbPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to import session '%s': %v", name, err)
+		}
+		imported = append(imported, Session{Name: name, State: "inactive", DBPath: dbPath})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Failed to commit session import: %v", err)
+	}
+	return imported, nil
+}
@@ -2,18 +2,29 @@ package session
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/frederikmartin/logwarts/internal/logger"
+	"github.com/mattn/go-sqlite3"
 )
 
+const InMemoryDBPath = ":memory:"
+
+// initAttempts is how often Init tries to set up a session database that other processes have locked
+const initAttempts = 5
+
+var ErrNoActiveSession = errors.New("No active session found")
+
 var (
-	sessionDB   *sql.DB
-	sessionLock sync.Mutex
+	sessionDB     *sql.DB
+	sessionLock   sync.Mutex
+	memorySession *Session
 )
 
 type Session struct {
@@ -23,12 +34,58 @@ type Session struct {
 	Name      string
 	State     string
 	DBPath    string
+	Defaults  Defaults
+}
+
+// Defaults are applied by query and stats for flags that were not set explicitly
+type Defaults struct {
+	Filter   string
+	Exclude  string
+	Format   string
+	Interval string
+}
+
+var defaultColumns = map[string]string{
+	"filter":   "default_filter",
+	"exclude":  "default_exclude",
+	"format":   "default_format",
+	"interval": "default_interval",
+}
+
+const sessionColumns = `id, created_at, updated_at, name, state, db_path, default_filter, default_exclude, default_format, default_interval`
+
+func scanSession(rows *sql.Rows) (Session, error) {
+	var session Session
+	err := rows.Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt, &session.Name, &session.State, &session.DBPath,
+		&session.Defaults.Filter, &session.Defaults.Exclude, &session.Defaults.Format, &session.Defaults.Interval)
+	return session, err
+}
+
+func (s *Session) InMemory() bool {
+	return s.DBPath == InMemoryDBPath
+}
+
+func UseInMemory() {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	now := time.Now()
+	memorySession = &Session{
+		CreatedAt: now,
+		UpdatedAt: now,
+		Name:      "memory",
+		State:     "active",
+		DBPath:    InMemoryDBPath,
+	}
 }
 
 func Init() error {
 	var err error
 	dbPath := filepath.Join(os.TempDir(), "logwarts_sessions.db")
-	sessionDB, err = sql.Open("sqlite3", dbPath)
+	// Several logwarts processes may share the session database: wait for locks instead of failing,
+	// use WAL so readers don't block writers and take the write lock when a transaction begins
+	dsn := dbPath + "?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+	sessionDB, err = sql.Open("sqlite3", dsn)
 	if err != nil {
 		return fmt.Errorf("Failed to open session database: %v", err)
 	}
@@ -37,6 +94,21 @@ func Init() error {
 		return fmt.Errorf("Failed to initialize session database: sessionDB is nil")
 	}
 
+	// Processes starting at the same time on a new database can find it locked while it is being set up,
+	// for example while another one switches it to WAL, which the busy timeout doesn't cover
+	for attempt := 1; ; attempt++ {
+		err = createSchema()
+		if err == nil || !isLocked(err) || attempt == initAttempts {
+			return err
+		}
+		logger.Debugf("Session database is locked, retrying (attempt %d of %d): %v", attempt, initAttempts, err)
+		time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+	}
+}
+
+// createSchema creates and upgrades the sessions table in one transaction, which takes the write lock when
+// it begins. In autocommit, processes starting at the same time could both try to add a missing column
+func createSchema() error {
 	createTableQuery := `
 	CREATE TABLE IF NOT EXISTS sessions (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -52,15 +124,68 @@ func Init() error {
 		BEGIN
 			UPDATE sessions SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
 	END;`
-	_, err = sessionDB.Exec(createTableQuery)
+
+	tx, err := sessionDB.Begin()
 	if err != nil {
-		return fmt.Errorf("Failed to create sessions table: %v", err)
+		return fmt.Errorf("Failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
+	_, err = tx.Exec(createTableQuery)
+	if err != nil {
+		return fmt.Errorf("Failed to create sessions table: %w", err)
+	}
+	if err := addDefaultColumns(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit sessions table: %w", err)
+	}
 	return nil
 }
 
-func CreateSession(name string, dbPath string) error {
+func isLocked(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+func addDefaultColumns(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info('sessions')`)
+	if err != nil {
+		return fmt.Errorf("Failed to read sessions table columns: %v", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("Failed to scan column name: %v", err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error during rows iteration: %v", err)
+	}
+
+	for _, column := range defaultColumns {
+		if existing[column] {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf(`ALTER TABLE sessions ADD COLUMN %s TEXT NOT NULL DEFAULT ''`, column))
+		if err != nil {
+			return fmt.Errorf("Failed to add column '%s' to sessions table: %v", column, err)
+		}
+	}
+	return nil
+}
+
+func DefaultKeys() []string {
+	return []string{"filter", "exclude", "format", "interval"}
+}
+
+// SetDefault stores a default option for the active session, an empty value removes it
+func SetDefault(key string, value string) error {
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
 
@@ -68,22 +193,57 @@ func CreateSession(name string, dbPath string) error {
 		return fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
 	}
 
-	inactivateSessionsQuery := `UPDATE sessions SET state = 'inactive' WHERE state = 'active'`
-	_, err := sessionDB.Exec(inactivateSessionsQuery)
+	column, ok := defaultColumns[key]
+	if !ok {
+		return fmt.Errorf("Unknown session option '%s', use one of: %s", key, strings.Join(DefaultKeys(), ", "))
+	}
+	result, err := sessionDB.Exec(fmt.Sprintf(`UPDATE sessions SET %s = ? WHERE state = 'active'`, column), value)
 	if err != nil {
-		return fmt.Errorf("Failed to inactivate sessions: %v", err)
+		return fmt.Errorf("Failed to set session option '%s': %v", key, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNoActiveSession
+	}
+	return nil
+}
+
+func CreateSession(name string, dbPath string) error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if sessionDB == nil {
+		return fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
 	}
 
 	sessionName, err := SanitizeSessionName(name)
 	if err != nil {
 		return fmt.Errorf("Invalid session name: %v", err)
 	}
-	insertQuery := `INSERT INTO sessions (name, state, db_path) VALUES (?, 'active', ?)`
-	_, err = sessionDB.Exec(insertQuery, sessionName, dbPath)
+
+	tx, err := sessionDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	insertQuery := `INSERT INTO sessions (name, state, db_path) VALUES (?, 'inactive', ?)`
+	result, err := tx.Exec(insertQuery, sessionName, dbPath)
 	if err != nil {
 		return fmt.Errorf("Failed to create session: %v", err)
 	}
-	fmt.Printf("Session '%s' created successfully\n", sessionName)
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Failed to read id of session '%s': %v", sessionName, err)
+	}
+
+	if err := activate(tx, id); err != nil {
+		return fmt.Errorf("Failed to activate session '%s': %v", sessionName, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit session creation: %v", err)
+	}
+	logger.Infof("Session '%s' created successfully\n", sessionName)
 	return nil
 }
 
@@ -95,10 +255,16 @@ func AttachSession(name string) error {
 		return fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
 	}
 
+	tx, err := sessionDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
 	selectQuery := `SELECT id, state FROM sessions WHERE name = ?`
-	row := sessionDB.QueryRow(selectQuery, name)
+	row := tx.QueryRow(selectQuery, name)
 	var sessionToAttach Session
-	err := row.Scan(&sessionToAttach.ID, &sessionToAttach.State)
+	err = row.Scan(&sessionToAttach.ID, &sessionToAttach.State)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return fmt.Errorf("Session with name '%s' not found: %v", name, err)
@@ -106,35 +272,92 @@ func AttachSession(name string) error {
 		return fmt.Errorf("Error scanning session state: %v", err)
 	}
 
-	inactivateSessionsQuery := `UPDATE sessions SET state = 'inactive' WHERE state = 'active'`
-	_, err = sessionDB.Exec(inactivateSessionsQuery)
-	if err != nil {
-		return fmt.Errorf("Failed to inactivate sessions: %v", err)
+	if err := activate(tx, int64(sessionToAttach.ID)); err != nil {
+		return fmt.Errorf("Failed to activate session '%s': %v", name, err)
 	}
 
-	activateSessionQuery := `UPDATE sessions SET state = 'active' WHERE id = ?`
-	_, err = sessionDB.Exec(activateSessionQuery, sessionToAttach.ID)
-	if err != nil {
-		return fmt.Errorf("Failed to activate session '%s': %v", name, err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit session attach: %v", err)
 	}
 
-	fmt.Printf("Attached to session: %s\n", name)
+	logger.Infof("Attached to session: %s\n", name)
 	return nil
 }
 
+func activate(tx *sql.Tx, id int64) error {
+	// Flip all states in a single statement so there is never more or less than one active session
+	query := `UPDATE sessions SET state = CASE WHEN id = ? THEN 'active' ELSE 'inactive' END WHERE state = 'active' OR id = ?`
+	_, err := tx.Exec(query, id, id)
+	return err
+}
+
 func GetActiveSession() (*Session, error) {
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
 
+	if memorySession != nil {
+		return memorySession, nil
+	}
+
 	if sessionDB == nil {
 		return nil, fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
 	}
 
-	selectQuery := `SELECT id, created_at, updated_at, name, state, db_path FROM sessions WHERE state = 'active'`
-	var session Session
-	row := sessionDB.QueryRow(selectQuery)
-	if err := row.Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt, &session.Name, &session.State, &session.DBPath); err != nil {
-		return nil, fmt.Errorf("No active session found")
+	selectQuery := `SELECT ` + sessionColumns + ` FROM sessions WHERE state = 'active' ORDER BY updated_at DESC, id DESC`
+	rows, err := sessionDB.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query active session: %v", err)
+	}
+	defer rows.Close()
+
+	var active []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read session data: %v", err)
+		}
+		active = append(active, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error during rows iteration: %v", err)
+	}
+
+	if len(active) == 0 {
+		return nil, ErrNoActiveSession
+	}
+	if len(active) > 1 {
+		names := make([]string, len(active))
+		for i, session := range active {
+			names[i] = session.Name
+		}
+		fmt.Fprintf(os.Stderr, "Warning: multiple active sessions found (%s), using most recently updated '%s'. Run 'logwarts session attach <name>' to fix\n", strings.Join(names, ", "), active[0].Name)
+	}
+	return &active[0], nil
+}
+
+func GetSession(name string) (*Session, error) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if sessionDB == nil {
+		return nil, fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
+	}
+
+	rows, err := sessionDB.Query(`SELECT `+sessionColumns+` FROM sessions WHERE name = ?`, name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query session '%s': %v", name, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("Error during rows iteration: %v", err)
+		}
+		return nil, fmt.Errorf("Session with name '%s' not found", name)
+	}
+	session, err := scanSession(rows)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read session data: %v", err)
 	}
 	return &session, nil
 }
@@ -148,7 +371,7 @@ func ListSessions() ([]Session, error) {
 	}
 
 	var sessions []Session
-	query := `SELECT id, created_at, updated_at, name, state, db_path FROM sessions`
+	query := `SELECT ` + sessionColumns + ` FROM sessions`
 	rows, err := sessionDB.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to list sessions: %v", err)
@@ -156,8 +379,8 @@ func ListSessions() ([]Session, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var session Session
-		if err := rows.Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt, &session.Name, &session.State, &session.DBPath); err != nil {
+		session, err := scanSession(rows)
+		if err != nil {
 			return nil, fmt.Errorf("Failed to read session data: %v", err)
 		}
 		sessions = append(sessions, session)
@@ -187,6 +410,22 @@ func KillSession() error {
 	return nil
 }
 
+// DeleteSession removes a session by name without touching its log db
+func DeleteSession(name string) error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if sessionDB == nil {
+		return fmt.Errorf("sessionDB is not initialized. Please call Initialize() first")
+	}
+
+	_, err := sessionDB.Exec(`DELETE FROM sessions WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("Failed to delete session '%s': %v", name, err)
+	}
+	return nil
+}
+
 func Close() error {
 	if sessionDB != nil {
 		return sessionDB.Close()
@@ -0,0 +1,243 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// initTestSessions points the session database at a fresh temporary directory
+func initTestSessions(t *testing.T) {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Close()
+		sessionDB = nil
+	})
+}
+
+func activeSessions(t *testing.T) []string {
+	t.Helper()
+	sessions, err := ListSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var active []string
+	for _, session := range sessions {
+		if session.State == "active" {
+			active = append(active, session.Name)
+		}
+	}
+	return active
+}
+
+// TestAttachHelperProcess attaches to a session in a separate process for TestConcurrentAttachKeepsOneActiveSession
+func TestAttachHelperProcess(t *testing.T) {
+	name := os.Getenv("LOGWARTS_TEST_ATTACH")
+	if name == "" {
+		t.Skip("Only runs as a helper process")
+	}
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	if err := AttachSession(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestInitHelperProcess opens the session database in a separate process for TestConcurrentInitAddsColumnsOnce
+func TestInitHelperProcess(t *testing.T) {
+	start, err := strconv.ParseInt(os.Getenv("LOGWARTS_TEST_INIT"), 10, 64)
+	if err != nil {
+		t.Skip("Only runs as a helper process")
+	}
+	// All helpers open the database at the same time, however long they took to start
+	time.Sleep(time.Until(time.Unix(0, start)))
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	Close()
+}
+
+func TestConcurrentInitAddsColumnsOnce(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	// Processes starting at the same time on a new session database all find the default columns missing
+	const processes = 16
+	start := time.Now().Add(time.Second).UnixNano()
+	var wg sync.WaitGroup
+	errs := make(chan error, processes)
+	for i := 0; i < processes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestInitHelperProcess$")
+			cmd.Env = append(os.Environ(), fmt.Sprintf("LOGWARTS_TEST_INIT=%d", start))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("Helper process failed: %v\n%s", err, out)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Close()
+		sessionDB = nil
+	})
+	if err := CreateSession("s", "/tmp/s.db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDefault("filter", "api"); err != nil {
+		t.Errorf("Default columns are missing after concurrent initialization: %v", err)
+	}
+}
+
+func TestConcurrentAttachKeepsOneActiveSession(t *testing.T) {
+	initTestSessions(t)
+	const sessions = 4
+	for i := 0; i < sessions; i++ {
+		if err := CreateSession(fmt.Sprintf("s%d", i), fmt.Sprintf("/tmp/s%d.db", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Goroutines of this process and other processes attach at the same time
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*sessions)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < sessions; i++ {
+			name := fmt.Sprintf("s%d", i)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := AttachSession(name); err != nil {
+					errs <- err
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				cmd := exec.Command(os.Args[0], "-test.run=^TestAttachHelperProcess$")
+				cmd.Env = append(os.Environ(), "LOGWARTS_TEST_ATTACH="+name)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					errs <- fmt.Errorf("Helper process attaching '%s' failed: %v\n%s", name, err, out)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if active := activeSessions(t); len(active) != 1 {
+		t.Errorf("Active sessions after concurrent attaches: %v, want exactly one", active)
+	}
+}
+
+func TestActiveSessionSurvivesInterleavedStateChanges(t *testing.T) {
+	initTestSessions(t)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := CreateSession(name, "/tmp/"+name+".db"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two separate updates, interrupted after the first, used to leave every session active
+	if _, err := sessionDB.Exec(`UPDATE sessions SET state = 'active'`); err != nil {
+		t.Fatal(err)
+	}
+	active, err := GetActiveSession()
+	if err != nil {
+		t.Fatalf("GetActiveSession with several active sessions: %v", err)
+	}
+	if active.Name != "c" {
+		t.Errorf("GetActiveSession picked '%s', want the most recent session 'c'", active.Name)
+	}
+	if err := AttachSession("a"); err != nil {
+		t.Fatal(err)
+	}
+	if active := activeSessions(t); len(active) != 1 || active[0] != "a" {
+		t.Errorf("Active sessions after attaching 'a': %v, want [a]", active)
+	}
+
+	// ...or none at all
+	if _, err := sessionDB.Exec(`UPDATE sessions SET state = 'inactive'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetActiveSession(); err != ErrNoActiveSession {
+		t.Errorf("GetActiveSession without an active session: %v, want ErrNoActiveSession", err)
+	}
+	if err := AttachSession("b"); err != nil {
+		t.Fatal(err)
+	}
+	if active := activeSessions(t); len(active) != 1 || active[0] != "b" {
+		t.Errorf("Active sessions after attaching 'b': %v, want [b]", active)
+	}
+
+	// Failed state changes roll back completely
+	if err := AttachSession("missing"); err == nil {
+		t.Error("Attaching a missing session succeeded")
+	}
+	if err := CreateSession("b", "/tmp/other.db"); err == nil {
+		t.Error("Creating a session with an existing name succeeded")
+	}
+	if active := activeSessions(t); len(active) != 1 || active[0] != "b" {
+		t.Errorf("Active sessions after failed state changes: %v, want [b]", active)
+	}
+}
+
+func TestImportSessionsKeepsNames(t *testing.T) {
+	initTestSessions(t)
+	if err := CreateSession("prod", "/data/prod.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportSessions(strings.NewReader(`[{"name": "prod", "db_path": "/data/prod.db"}, {"name": "staging", "db_path": "/data/staging.db"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 || imported[0].Name != "staging" {
+		t.Errorf("Imported %v, want only staging with the exact duplicate skipped", imported)
+	}
+
+	// The log tables in the db files are named after the sessions, renamed sessions would point at missing tables
+	for _, input := range []string{
+		`[{"name": "qa", "db_path": "/data/qa.db"}, {"name": "prod", "db_path": "/other/prod.db"}]`,
+		`[{"name": "qa", "db_path": "/data/qa.db"}, {"name": "Prod-EU", "db_path": "/data/prod-eu.db"}]`,
+	} {
+		if imported, err := ImportSessions(strings.NewReader(input)); err == nil {
+			t.Errorf("ImportSessions(%s) = %v, want an error", input, imported)
+		}
+	}
+	sessions, err := ListSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, session := range sessions {
+		names = append(names, session.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "prod staging" {
+		t.Errorf("Sessions after rejected imports: %v, want prod and staging", names)
+	}
+}
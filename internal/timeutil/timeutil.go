@@ -0,0 +1,36 @@
+package timeutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var layouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var acceptedFormats = []string{
+	"2006-01-02T15:04:05Z07:00 (RFC3339)",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Inputs without a zone are interpreted as UTC, a bare date as midnight UTC
+func ParseTimeFlexible(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Cannot parse time '%s', accepted formats are: %s", value, strings.Join(acceptedFormats, ", "))
+}